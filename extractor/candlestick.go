@@ -2,10 +2,15 @@ package extractor
 
 import exchange "github.com/preichenberger/go-coinbase-exchange"
 
+// SourceGDAX is the source of candlesticks retrieved from the GDAX API
+const SourceGDAX = "gdax"
+
 // Candlestick is a representation of trades that ocurred in a block of time.
 // this redirection is necessary to simplify buffer usage with a string-type time,
-// And to add granularity to the set of tracked data
+// And to add granularity, product, and source exchange to the set of tracked data
 type Candlestick struct {
+	Product     string  `json:"product"`
+	Source      string  `json:"source"`
 	Datetime    string  `json:"datetime"`
 	Granularity int     `json:"granularity"`
 	Low         float64 `json:"low"`
@@ -14,12 +19,16 @@ type Candlestick struct {
 	Close       float64 `json:"close"`
 	Volume      float64 `json:"volume"`
 	Timestamp   int64   `json:"timestamp"`
+	// Fields holds additional values computed for the candlestick, such as technical indicators
+	Fields map[string]float64 `json:"fields,omitempty"`
 }
 
-// CandleFromRate takes the granularity int and historic rate and converts it to a candlestick struct
-func CandleFromRate(granularity int, rt *exchange.HistoricRate) Candlestick {
+// CandleFromRate takes the product, granularity int, and historic rate and converts it to a candlestick struct
+func CandleFromRate(product string, granularity int, rt *exchange.HistoricRate) Candlestick {
 	utc := rt.Time.UTC()
 	return Candlestick{
+		Product:     product,
+		Source:      SourceGDAX,
 		Datetime:    utc.String(),
 		Granularity: granularity,
 		Low:         rt.Low,
@@ -31,11 +40,24 @@ func CandleFromRate(granularity int, rt *exchange.HistoricRate) Candlestick {
 	}
 }
 
-// CandlesFromRates takes the granularity int and list of historic rates and converts them to a list of candlestick structs
-func CandlesFromRates(granularity int, rts []exchange.HistoricRate) []Candlestick {
+// CandlesFromRates takes the product, granularity int, and list of historic rates and converts them to a list of candlestick structs
+func CandlesFromRates(product string, granularity int, rts []exchange.HistoricRate) []Candlestick {
 	cdls := make([]Candlestick, len(rts))
 	for i, rt := range rts {
-		cdls[i] = CandleFromRate(granularity, &rt)
+		cdls[i] = CandleFromRate(product, granularity, &rt)
 	}
 	return cdls
 }
+
+// Merge folds a later candlestick from the same bucket into the candlestick, as when aggregating to a
+// coarser granularity. the open and time of the candlestick are kept
+func (c *Candlestick) Merge(later *Candlestick) {
+	if later.High > c.High {
+		c.High = later.High
+	}
+	if later.Low < c.Low {
+		c.Low = later.Low
+	}
+	c.Close = later.Close
+	c.Volume += later.Volume
+}
@@ -0,0 +1,144 @@
+package extractor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// CheckpointStore persists extraction progress, allowing an interrupted extraction to be resumed.
+// progress is keyed by product and granularity, and is the end of the last fully delivered range
+type CheckpointStore interface {
+	Load(product string, granularity int) (time.Time, bool, error)
+	Save(product string, granularity int, end time.Time) error
+}
+
+// FileCheckpoint implements CheckpointStore as a JSON file
+type FileCheckpoint struct {
+	Path  string
+	Mutex *sync.Mutex
+}
+
+// NewFileCheckpoint builds a file checkpoint store. the file is created on the first save
+func NewFileCheckpoint(path string) *FileCheckpoint {
+	return &FileCheckpoint{
+		Path:  path,
+		Mutex: &sync.Mutex{},
+	}
+}
+
+// Load returns the checkpoint for the product and granularity, if one has been saved
+func (f *FileCheckpoint) Load(product string, granularity int) (time.Time, bool, error) {
+	f.Mutex.Lock()
+	defer f.Mutex.Unlock()
+	cps, err := f.read()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	t, ok := cps[checkpointKey(product, granularity)]
+	return t, ok, nil
+}
+
+// Save records the checkpoint for the product and granularity, leaving any others in the file intact
+func (f *FileCheckpoint) Save(product string, granularity int, end time.Time) error {
+	f.Mutex.Lock()
+	defer f.Mutex.Unlock()
+	cps, err := f.read()
+	if err != nil {
+		return err
+	}
+	cps[checkpointKey(product, granularity)] = end.UTC()
+
+	b, err := json.MarshalIndent(cps, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a crash mid-write can't corrupt the checkpoint
+	tmp := f.Path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.Path)
+}
+
+func (f *FileCheckpoint) read() (map[string]time.Time, error) {
+	cps := map[string]time.Time{}
+	b, err := ioutil.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return cps, nil
+	}
+	if err != nil {
+		return cps, err
+	}
+	if err = json.Unmarshal(b, &cps); err != nil {
+		return cps, fmt.Errorf("Invalid checkpoint file [%s]: %s", f.Path, err.Error())
+	}
+	return cps, nil
+}
+
+func checkpointKey(product string, granularity int) string {
+	return fmt.Sprintf("%s:%d", product, granularity)
+}
+
+// pendingRange tracks delivery of a requested range which has not yet been checkpointed. unacked counts
+// the candlesticks sent of each timestamp, as the boundary candlestick may be sent by two ranges
+type pendingRange struct {
+	end       time.Time
+	unacked   map[int64]int
+	remaining int
+	failed    bool
+}
+
+// Ack acknowledges that a candlestick received from the extractor has been delivered. once every
+// candlestick of a range is acknowledged, the range is saved to the checkpoint store. ranges which
+// failed to be retrieved are never checkpointed, so a resumed extraction will retry them, and neither
+// are ranges with a candlestick which is never acknowledged, or any range after them
+func (m *Extractor) Ack(cdl *Candlestick) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, p := range m.pending[cdl.Product] {
+		if p.unacked[cdl.Timestamp] > 0 {
+			p.unacked[cdl.Timestamp]--
+			p.remaining--
+			break
+		}
+	}
+	return m.advanceCheckpoint(cdl.Product)
+}
+
+// trackRange registers a range before its candlesticks are sent, so they can be acknowledged
+func (m *Extractor) trackRange(product string, end time.Time, cdls []Candlestick, failed bool) error {
+	if m.Config.Checkpoint == nil {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	p := &pendingRange{end: end, unacked: map[int64]int{}, remaining: len(cdls), failed: failed}
+	for _, c := range cdls {
+		p.unacked[c.Timestamp]++
+	}
+	m.pending[product] = append(m.pending[product], p)
+	return m.advanceCheckpoint(product)
+}
+
+// advanceCheckpoint saves the end of the last contiguous delivered range of the product. the mutex must be held
+func (m *Extractor) advanceCheckpoint(product string) error {
+	if m.Config.Checkpoint == nil {
+		return nil
+	}
+	var last *pendingRange
+	pending := m.pending[product]
+	for len(pending) > 0 && pending[0].remaining == 0 && !pending[0].failed {
+		last = pending[0]
+		pending = pending[1:]
+	}
+	m.pending[product] = pending
+	if last == nil {
+		return nil
+	}
+	return m.Config.Checkpoint.Save(product, m.Config.Extraction.Granularity, last.end)
+}
@@ -0,0 +1,104 @@
+package extractor_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/gdaxtest"
+)
+
+func TestResumeDoesNotDuplicate(t *testing.T) {
+	cases := []struct {
+		name        string
+		granularity int
+		// split is where the first extraction ends and the resumed one continues
+		split time.Duration
+		end   time.Duration
+	}{
+		{"supported granularity", 60, 400 * time.Minute, 1000 * time.Minute},
+		{"unaligned checkpoint", 60, 400*time.Minute + 30*time.Second, 1000 * time.Minute},
+		{"aggregated granularity", 7200, 150 * 2 * time.Hour, 400 * 2 * time.Hour},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := gdaxtest.NewServer(nil)
+			defer srv.Close()
+			dir, err := ioutil.TempDir("", "checkpoint")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			store := extractor.NewFileCheckpoint(filepath.Join(dir, "checkpoint.json"))
+
+			run := func(end time.Time) []*extractor.Candlestick {
+				ext := newExtractor(srv, testStart, end, func(c *extractor.ExtractorConfig) {
+					c.Checkpoint = store
+					c.Resume = true
+					c.Extraction.Granularity = tc.granularity
+					c.Extraction.Aggregate = true
+				})
+				cdls, errs, err := collect(t, context.Background(), ext, nil)
+				if err != nil || len(errs) > 0 {
+					t.Fatalf("unexpected errors: %v %v", err, errs)
+				}
+				return cdls
+			}
+
+			first := run(testStart.Add(tc.split))
+			cp, ok, err := store.Load(product, tc.granularity)
+			if err != nil || !ok || !cp.Equal(testStart.Add(tc.split)) {
+				t.Fatalf("expected a checkpoint at %s, got %s %t %v", testStart.Add(tc.split), cp, ok, err)
+			}
+			second := run(testStart.Add(tc.end))
+			if len(first) == 0 || len(second) == 0 {
+				t.Fatalf("expected both extractions to collect candlesticks, got %d and %d", len(first), len(second))
+			}
+
+			// together, the extractions are every candlestick of the range once, in order
+			all := append(first, second...)
+			g := int64(tc.granularity)
+			for i, c := range all {
+				if want := testStart.Unix() + int64(i)*g; c.Timestamp != want {
+					t.Fatalf("candlestick %d: expected timestamp %d, got %d", i, want, c.Timestamp)
+				}
+			}
+			if tc.granularity == 60 {
+				assertCandles(t, all, srv.Candles(product, testStart, testStart.Add(tc.end), 60))
+			}
+		})
+	}
+}
+
+func TestCheckpointWaitsForDelivery(t *testing.T) {
+	srv := gdaxtest.NewServer(nil)
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := extractor.NewFileCheckpoint(filepath.Join(dir, "checkpoint.json"))
+
+	// the first range fails every attempt, so nothing after it may be checkpointed
+	srv.Config.ErrorEvery = 1
+	end := testStart.Add(1000 * time.Minute)
+	ext := newExtractor(srv, testStart, end, func(c *extractor.ExtractorConfig) {
+		c.Checkpoint = store
+		c.Retry.MaxAttempts = 1
+	})
+	_, errs, err := collect(t, context.Background(), ext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 5 {
+		t.Fatalf("expected every range to fail, got %v", errs)
+	}
+	if _, ok, _ := store.Load(product, 60); ok {
+		t.Error("expected no checkpoint after failed ranges")
+	}
+}
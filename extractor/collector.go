@@ -1,11 +1,17 @@
 package extractor
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultMaxHeld is the most candlesticks held for transforms which hold candlesticks back if no limit is configured
+const DefaultMaxHeld = 100000
+
 // Collector acts as an N fanout pipe from an extractor to receivers. It also simplifies collection by abstracting channel complexity
 type Collector struct {
 	// Extractor is expected to pass candlesticks and errors over their respective channels
@@ -14,8 +20,35 @@ type Collector struct {
 	Receivers []Receiver
 	// Error handler syncronously passes errors from the extrator to the function. Default function prints to stdout
 	ErrorHandler func(error)
+	// GapHandler syncronously passes gaps from extractors implementing GapReporter. Default function prints to stdout
+	GapHandler func(*GapEvent)
+	// Logger is used by the default error and gap handlers in place of stdout, e.g. to redact secrets
+	Logger Logger
+	// Delivery configures the buffering of receivers added without their own delivery config
+	Delivery *DeliveryConfig
+	// Transforms are applied in order to every candlestick before it's passed to the receivers
+	Transforms []Transform
+	// MaxHeld is the most candlesticks held for the collector's transforms, or each receiver's transforms,
+	// which hold candlesticks back. they can't be acknowledged until the transforms are flushed at the end
+	// of the extraction, so past the limit they're released unacknowledged and nothing after is checkpointed
+	MaxHeld int
 	// running tracks whether or not the collecter is active
 	running bool
+	mutex   sync.Mutex
+	// configs holds the delivery config of each receiver added with AddWithDelivery, by index
+	configs    []*DeliveryConfig
+	deliveries []*delivery
+	errMutex   sync.Mutex
+	acks       map[uint64]*pendingAck
+	ackMutex   sync.Mutex
+	// held is the candlesticks passed to transforms which hold candlesticks back, acknowledged once the
+	// transforms are flushed unless lost is set by anything they returned not being delivered
+	held []*Candlestick
+	lost bool
+	// aborted is the error which aborted the collection under ErrorPolicyFailFast
+	aborted error
+	// ctx is the context of the running collection, which cancels retries
+	ctx context.Context
 }
 
 // CollectorConfig encapsulates the collection configuration and process
@@ -26,6 +59,17 @@ type CollectorConfig struct {
 	Receivers []Receiver
 	// Override the default error handler, which prints to stdout
 	ErrorHandler func(error)
+	// Override the default gap handler, which prints to stdout
+	GapHandler func(*GapEvent)
+	// Logger prints for the default error and gap handlers in place of stdout
+	Logger Logger
+	// Delivery configures the buffering of each receiver. receivers block when a buffer of
+	// DefaultDeliveryBufferSize is full if nil
+	Delivery *DeliveryConfig
+	// Transforms are applied in order to every candlestick before it's passed to the receivers
+	Transforms []Transform
+	// MaxHeld limits the candlesticks held for transforms which hold candlesticks back. defaults to DefaultMaxHeld
+	MaxHeld int
 }
 
 // Collectable provides an abstraction to allow any etractor impementation to be used
@@ -35,74 +79,554 @@ type Collectable interface {
 	Stop()
 }
 
+// Acknowledger may be implemented by a Collectable to be notified once a candlestick has been
+// passed to every receiver, e.g. to checkpoint progress
+type Acknowledger interface {
+	Ack(*Candlestick) error
+}
+
+// GapReporter may be implemented by a Collectable to report candlesticks missing from the extraction
+type GapReporter interface {
+	Gaps() chan *GapEvent
+}
+
 // NewCollector builds a collector with the provided chan, and using any receivers provided
 func NewCollector(config *CollectorConfig) *Collector {
 	c := &Collector{
-		Extractor: config.Extractor,
-		Receivers: config.Receivers,
+		Extractor:  config.Extractor,
+		Receivers:  config.Receivers,
+		Delivery:   config.Delivery,
+		Transforms: config.Transforms,
+		MaxHeld:    config.MaxHeld,
+		Logger:     config.Logger,
+	}
+	if c.MaxHeld <= 0 {
+		c.MaxHeld = DefaultMaxHeld
 	}
 	if config.ErrorHandler != nil {
 		c.ErrorHandler = config.ErrorHandler
 	} else {
 		c.ErrorHandler = func(e error) {
-			fmt.Printf("Extraction Error: %s\n", e.Error())
+			c.printf("Extraction Error: %s\n", e.Error())
+		}
+	}
+	if config.GapHandler != nil {
+		c.GapHandler = config.GapHandler
+	} else {
+		c.GapHandler = func(g *GapEvent) {
+			c.printf("Extraction Gap: %s\n", g.String())
 		}
 	}
 	return c
 }
 
+// printf prints with the logger, or to stdout if there isn't one
+func (c *Collector) printf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+		return
+	}
+	fmt.Printf(format, v...)
+}
+
 // Add adds the receiver to the list of reveivers to be used when the collection fires
 func (c *Collector) Add(r Receiver) {
 	c.Receivers = append(c.Receivers, r)
 }
 
+// AddWithDelivery adds the receiver, buffering candlesticks for it according to the config
+// rather than the collector's Delivery
+func (c *Collector) AddWithDelivery(r Receiver, config *DeliveryConfig) {
+	for len(c.configs) < len(c.Receivers) {
+		c.configs = append(c.configs, nil)
+	}
+	c.Receivers = append(c.Receivers, r)
+	c.configs = append(c.configs, config)
+}
+
+// Stats returns the delivery stats of each receiver of the current or last collection
+func (c *Collector) Stats() []ReceiverStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := make([]ReceiverStats, len(c.deliveries))
+	for i, d := range c.deliveries {
+		stats[i] = d.snapshot()
+	}
+	return stats
+}
+
 // Collect collects from either the collectors chan, or the chan param, if provided
 func (c *Collector) Collect() error {
+	return c.CollectContext(context.Background())
+}
+
+// CollectContext collects as Collect does. Cancelling the context stops the extractor, and the
+// channels are drained until the extractor closes them. the context error is returned if cancelled,
+// otherwise a *CollectionError is returned if any receiver failed to collect candlesticks
+func (c *Collector) CollectContext(ctx context.Context) error {
+	c.mutex.Lock()
 	if c.running {
+		c.mutex.Unlock()
 		return errors.New("Collection already started")
 	}
+	c.running = true
+	c.mutex.Unlock()
 	defer c.Close()
 
 	if len(c.Receivers) == 0 {
 		return errors.New("No receivers set for the collector when Collect was called")
 	}
 
-	c.running = true
+	// Stop the extractor if the context is cancelled before the channels are closed
+	done := make(chan struct{})
+	stopped := make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Extractor.Stop()
+			stopped <- ctx.Err()
+		case <-done:
+			stopped <- nil
+		}
+	}()
+
+	// Each receiver collects from its own buffer, so a slow receiver doesn't hold up the others
+	deliveries := make([]*delivery, len(c.Receivers))
+	for i, rcv := range c.Receivers {
+		config := c.Delivery
+		if i < len(c.configs) && c.configs[i] != nil {
+			config = c.configs[i]
+		}
+		deliveries[i] = newDelivery(rcv, config)
+	}
+	c.mutex.Lock()
+	c.deliveries = deliveries
+	c.acks = map[uint64]*pendingAck{}
+	c.held, c.lost = nil, false
+	c.aborted = nil
+	c.ctx = ctx
+	c.mutex.Unlock()
+
+	var rcvWg sync.WaitGroup
+	for _, d := range deliveries {
+		rcvWg.Add(1)
+		go func(d *delivery) {
+			defer rcvWg.Done()
+			c.deliver(d)
+		}(d)
+	}
+
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		var seq uint64
 		for cdl := range c.Extractor.Candlesticks() {
-			c.fanOut(cdl)
+			seq++
+			c.fanOut(deliveries, seq, cdl)
+		}
+		c.flushTransforms(deliveries)
+		for _, d := range deliveries {
+			d.close()
 		}
+		rcvWg.Wait()
+		c.ackHeld()
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		for err := range c.Extractor.Errors() {
-			c.ErrorHandler(err)
+			c.handleError(err)
 		}
 	}()
+
+	if gr, ok := c.Extractor.(GapReporter); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for gap := range gr.Gaps() {
+				c.GapHandler(gap)
+			}
+		}()
+	}
 	wg.Wait()
-	c.Close()
-	return nil
+	close(done)
+	if err := <-stopped; err != nil {
+		return err
+	}
+	return c.collectionError()
+}
+
+// pendingAck tracks the receivers yet to handle a candlestick before it's acknowledged. lost is set if
+// any receiver didn't have it delivered, and it's never acknowledged
+type pendingAck struct {
+	cdl       *Candlestick
+	remaining int
+	lost      bool
+}
+
+// fanOut passes the candlestick through the collector's transforms, then to the buffer of every receiver.
+// candlesticks produced by a transform share the sequence of the one they were produced from, which is
+// acknowledged once every receiver has had all of them delivered. if the transforms hold candlesticks
+// back, their output is passed on without a sequence and the candlestick is held until they're flushed,
+// up to MaxHeld candlesticks
+func (c *Collector) fanOut(deliveries []*delivery, seq uint64, cdl *Candlestick) {
+	cdls := []*Candlestick{cdl}
+	failed := false
+	if len(c.Transforms) > 0 {
+		out, err := Chain(c.Transforms).Apply(cdl)
+		if err != nil {
+			c.handleError(err)
+			failed = true
+		}
+		cdls = out
+	}
+
+	if ack, ok := c.Extractor.(Acknowledger); ok {
+		if Chain(c.Transforms).HoldsBack() {
+			c.ackMutex.Lock()
+			if len(c.held) < c.MaxHeld && !c.lost {
+				c.held = append(c.held, cdl)
+			} else {
+				// nothing held will be acknowledged, so it isn't kept
+				c.held, c.lost = nil, true
+			}
+			c.ackMutex.Unlock()
+			if failed {
+				c.handled(0, false)
+			}
+			c.push(deliveries, 0, cdls)
+			return
+		}
+		if failed {
+			// left unacknowledged, so it isn't checkpointed
+			c.push(deliveries, 0, cdls)
+			return
+		}
+		if len(cdls) == 0 {
+			// filtered out entirely, so there's nothing to wait for
+			if err := ack.Ack(cdl); err != nil {
+				c.handleError(err)
+			}
+			return
+		}
+		c.ackMutex.Lock()
+		c.acks[seq] = &pendingAck{cdl: cdl, remaining: len(cdls) * len(deliveries)}
+		c.ackMutex.Unlock()
+	}
+	c.push(deliveries, seq, cdls)
+}
+
+// flushTransforms passes the candlesticks held back by the collector's transforms to every receiver
+func (c *Collector) flushTransforms(deliveries []*delivery) {
+	if len(c.Transforms) == 0 {
+		return
+	}
+	cdls, err := Chain(c.Transforms).Flush()
+	if err != nil {
+		c.handleError(err)
+		c.handled(0, false)
+	}
+	c.push(deliveries, 0, cdls)
+}
+
+func (c *Collector) push(deliveries []*delivery, seq uint64, cdls []*Candlestick) {
+	for _, cdl := range cdls {
+		env := &envelope{Seq: seq, Candlestick: cdl}
+		for _, d := range deliveries {
+			dropped, err := d.push(env)
+			if err != nil {
+				c.handleError(err)
+			}
+			if dropped != nil {
+				c.handled(dropped.Seq, false)
+			}
+		}
+	}
+}
+
+// deliver passes buffered candlesticks to the receiver until the delivery is closed and drained
+func (c *Collector) deliver(d *delivery) {
+	defer func() {
+		if f, ok := d.receiver.(Finisher); ok {
+			if err := f.Finish(); err != nil {
+				err = fmt.Errorf("Failed to finish %T: %s", d.receiver, err.Error())
+				d.recordFinishError(err)
+				c.handleError(err)
+			}
+		}
+		if err := d.removeSpill(); err != nil {
+			c.handleError(err)
+		}
+		if err := d.closeDeadLetters(); err != nil {
+			c.handleError(err)
+		}
+	}()
+	if br, ok := d.receiver.(BatchReceiver); ok {
+		c.deliverBatches(d, br)
+		return
+	}
+	for {
+		env, ok, err := d.pop(time.Time{})
+		if !ok {
+			if !c.collect(d, c.flush(d)) {
+				d.lost = true
+			}
+			c.releaseHeld(d)
+			return
+		}
+		if err != nil {
+			c.failRead(d, err)
+			continue
+		}
+		cdls, ok := c.transform(d, env.Candlestick)
+		ok = c.collect(d, cdls) && ok
+		// candlesticks are left unacknowledged once aborted, so they aren't checkpointed
+		if !d.isAborted() {
+			c.release(d, env.Seq, ok)
+		}
+	}
+}
+
+// collect passes each candlestick to the receiver, applying its error policy to failures. false is
+// returned if any wasn't delivered or dead lettered
+func (c *Collector) collect(d *delivery, cdls []*Candlestick) bool {
+	delivered := true
+	for _, cdl := range cdls {
+		if d.isAborted() {
+			return false
+		}
+		err := c.attempt(d, func() error {
+			return d.receiver.Collect(cdl)
+		})
+		if err != nil {
+			delivered = c.fail(d, []*Candlestick{cdl}, err) && delivered
+		} else {
+			atomic.AddInt64(&d.stats.Delivered, 1)
+		}
+	}
+	return delivered
 }
 
-func (c *Collector) fanOut(cdl *Candlestick) (err error) {
-	for _, rcv := range c.Receivers {
-		cErr := rcv.Collect(cdl)
-		if cErr != nil {
-			c.ErrorHandler(cErr)
+// deliverBatches passes buffered candlesticks to the receiver once the batch is full, or the first
+// candlestick of the batch has waited for the batch latency
+func (c *Collector) deliverBatches(d *delivery, br BatchReceiver) {
+	var batch []*Candlestick
+	var seqs []uint64
+	// failed is set for the sequences the receiver's transforms failed
+	var failed []bool
+	var deadline time.Time
+	for {
+		env, ok, err := d.pop(deadline)
+		if err != nil {
+			c.failRead(d, err)
+			continue
+		}
+		if env != nil {
+			if len(seqs) == 0 {
+				deadline = time.Now().Add(d.config.BatchLatency)
+			}
+			cdls, ok := c.transform(d, env.Candlestick)
+			batch = append(batch, cdls...)
+			seqs = append(seqs, env.Seq)
+			failed = append(failed, !ok)
+			if len(batch) < d.config.BatchSize {
+				continue
+			}
+		}
+		if !ok {
+			batch = append(batch, c.flush(d)...)
+		}
+
+		// the batch is full, the deadline passed, or the delivery is closed
+		if len(seqs) > 0 || len(batch) > 0 {
+			c.collectBatch(d, br, batch, seqs, failed)
+			batch, seqs, failed = nil, nil, nil
+			deadline = time.Time{}
 		}
+		if !ok {
+			c.releaseHeld(d)
+			return
+		}
+	}
+}
+
+// collectBatch passes the candlesticks to the receiver at once, then acknowledges the sequences they
+// were produced from, unless the batch or the receiver's transforms failed without dead lettering
+func (c *Collector) collectBatch(d *delivery, br BatchReceiver, batch []*Candlestick, seqs []uint64, failed []bool) {
+	if d.isAborted() {
+		return
+	}
+	delivered := true
+	if len(batch) > 0 {
+		err := c.attempt(d, func() error {
+			return br.CollectBatch(batch)
+		})
+		if err != nil {
+			delivered = c.fail(d, batch, err)
+		} else {
+			atomic.AddInt64(&d.stats.Delivered, int64(len(batch)))
+		}
+		if d.isAborted() {
+			return
+		}
+	}
+	if !delivered && len(seqs) == 0 {
+		// only flushed candlesticks, which the held sequences were waiting on
+		d.lost = true
+	}
+	for i, seq := range seqs {
+		c.release(d, seq, delivered && !failed[i])
+	}
+}
+
+// transform applies the receiver's transforms to the candlestick. a transform error is a failure of the
+// receiver, so its error policy applies. false is returned if it failed without being dead lettered
+func (c *Collector) transform(d *delivery, cdl *Candlestick) ([]*Candlestick, bool) {
+	if len(d.config.Transforms) == 0 {
+		return []*Candlestick{cdl}, true
+	}
+	cdls, err := Chain(d.config.Transforms).Apply(cdl)
+	if err != nil {
+		return cdls, c.fail(d, []*Candlestick{cdl}, err)
+	}
+	return cdls, true
+}
+
+// flush returns the candlesticks held back by the receiver's transforms
+func (c *Collector) flush(d *delivery) []*Candlestick {
+	if len(d.config.Transforms) == 0 || d.isAborted() {
+		return nil
+	}
+	cdls, err := Chain(d.config.Transforms).Flush()
+	if err != nil {
+		atomic.AddInt64(&d.stats.Failed, 1)
+		d.recordError(err)
+		c.handleError(err)
+		d.lost = true
 	}
-	return nil
+	return cdls
+}
+
+// failRead reports a candlestick which couldn't be read back from the spill file
+func (c *Collector) failRead(d *delivery, err error) {
+	atomic.AddInt64(&d.stats.Failed, 1)
+	d.recordError(err)
+	c.handleError(err)
+}
+
+// release records that the receiver is done with the sequence, as handled does. if the receiver's
+// transforms hold candlesticks back, the sequence is held until they're flushed, up to MaxHeld sequences
+func (c *Collector) release(d *delivery, seq uint64, delivered bool) {
+	if !d.holds {
+		c.handled(seq, delivered)
+		return
+	}
+	d.held = append(d.held, seq)
+	d.lost = d.lost || !delivered || len(d.held) > c.MaxHeld
+	if d.lost {
+		// nothing held will be acknowledged, so the sequences are released now rather than kept
+		c.releaseHeld(d)
+	}
+}
+
+// releaseHeld releases the sequences held for the receiver's transforms once they're flushed. they're
+// only delivered if everything since the first was
+func (c *Collector) releaseHeld(d *delivery) {
+	if d.isAborted() {
+		return
+	}
+	for _, seq := range d.held {
+		c.handled(seq, !d.lost)
+	}
+	d.held = nil
+}
+
+// handled records that a receiver is done with a candlestick, and whether it was delivered. the
+// candlestick is acknowledged once every receiver is done with it, only if every one delivered it.
+// candlesticks without a sequence were produced by transforms which hold candlesticks back
+func (c *Collector) handled(seq uint64, delivered bool) {
+	ack, ok := c.Extractor.(Acknowledger)
+	if !ok {
+		return
+	}
+	c.ackMutex.Lock()
+	if seq == 0 {
+		c.lost = c.lost || !delivered
+		c.ackMutex.Unlock()
+		return
+	}
+	p := c.acks[seq]
+	if p == nil {
+		c.ackMutex.Unlock()
+		return
+	}
+	p.lost = p.lost || !delivered
+	p.remaining--
+	if p.remaining > 0 {
+		c.ackMutex.Unlock()
+		return
+	}
+	delete(c.acks, seq)
+	c.ackMutex.Unlock()
+
+	if p.lost {
+		return
+	}
+	if err := ack.Ack(p.cdl); err != nil {
+		c.handleError(err)
+	}
+}
+
+// ackHeld acknowledges the candlesticks held for the collector's transforms, once they're flushed and
+// every receiver is done, if everything they returned was delivered
+func (c *Collector) ackHeld() {
+	ack, ok := c.Extractor.(Acknowledger)
+	if !ok {
+		return
+	}
+	c.mutex.Lock()
+	aborted := c.aborted != nil
+	c.mutex.Unlock()
+	c.ackMutex.Lock()
+	held, lost := c.held, c.lost
+	c.held = nil
+	c.ackMutex.Unlock()
+	if aborted || lost {
+		return
+	}
+	for _, cdl := range held {
+		if err := ack.Ack(cdl); err != nil {
+			c.handleError(err)
+			return
+		}
+	}
+}
+
+// context returns the context of the running collection
+func (c *Collector) context() context.Context {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// handleError passes the error to the error handler, one at a time as receivers report errors concurrently
+func (c *Collector) handleError(err error) {
+	c.errMutex.Lock()
+	defer c.errMutex.Unlock()
+	c.ErrorHandler(err)
 }
 
 // Close stops the extractor and closes all receivers
 func (c *Collector) Close() {
+	c.mutex.Lock()
 	c.running = false
+	c.mutex.Unlock()
 
 	// Close all receivers
 	for i := range c.Receivers {
@@ -0,0 +1,285 @@
+package extractor_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/gdaxtest"
+)
+
+// gatedReceiver blocks its first collection until the gate is closed
+type gatedReceiver struct {
+	memReceiver
+	gate   chan struct{}
+	passed bool
+}
+
+func (r *gatedReceiver) Collect(c *extractor.Candlestick) error {
+	if !r.passed {
+		<-r.gate
+		r.passed = true
+	}
+	return r.memReceiver.Collect(c)
+}
+
+// holdAll holds every candlestick back until it's flushed, calling onFlush first
+type holdAll struct {
+	held    []*extractor.Candlestick
+	onFlush func()
+}
+
+func (h *holdAll) Apply(c *extractor.Candlestick) ([]*extractor.Candlestick, error) {
+	h.held = append(h.held, c)
+	return nil, nil
+}
+
+func (h *holdAll) Flush() ([]*extractor.Candlestick, error) {
+	h.onFlush()
+	return h.held, nil
+}
+
+// newCheckpoint returns a checkpoint store in a temporary directory, and a function removing it
+func newCheckpoint(t *testing.T) (*extractor.FileCheckpoint, func()) {
+	dir, err := ioutil.TempDir("", "collector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return extractor.NewFileCheckpoint(filepath.Join(dir, "checkpoint.json")), func() { os.RemoveAll(dir) }
+}
+
+func TestDroppedCandlesNotCheckpointed(t *testing.T) {
+	for _, bp := range []extractor.Backpressure{extractor.BackpressureDropOldest, extractor.BackpressureDropNewest} {
+		srv := gdaxtest.NewServer(nil)
+		defer srv.Close()
+		store, cleanup := newCheckpoint(t)
+		defer cleanup()
+
+		end := testStart.Add(1000 * time.Minute)
+		ext := newExtractor(srv, testStart, end, func(c *extractor.ExtractorConfig) {
+			c.Checkpoint = store
+		})
+		rcv := &gatedReceiver{gate: make(chan struct{})}
+		col := extractor.NewCollector(&extractor.CollectorConfig{
+			Extractor: ext,
+			Receivers: []extractor.Receiver{rcv},
+			Delivery:  &extractor.DeliveryConfig{BufferSize: 1, Backpressure: bp},
+		})
+		if err := ext.Start(); err != nil {
+			t.Fatal(err)
+		}
+		// the receiver is held up until every candlestick has been passed to the collector
+		go func() {
+			ext.Wait()
+			close(rcv.gate)
+		}()
+		if err := col.Collect(); err != nil {
+			t.Fatal(err)
+		}
+
+		stats := col.Stats()[0]
+		if stats.Dropped == 0 {
+			t.Fatalf("backpressure %d: expected candlesticks to be dropped", bp)
+		}
+		if _, ok, _ := store.Load(product, 60); ok {
+			t.Errorf("backpressure %d: expected no checkpoint after %d candlesticks were dropped", bp, stats.Dropped)
+		}
+	}
+}
+
+// holdingConfigs add a transform which holds candlesticks back to the collector, or to its receiver
+var holdingConfigs = []struct {
+	name      string
+	configure func(*extractor.CollectorConfig, extractor.Transform)
+}{
+	{"collector transform", func(c *extractor.CollectorConfig, tf extractor.Transform) {
+		c.Transforms = []extractor.Transform{tf}
+	}},
+	{"receiver transform", func(c *extractor.CollectorConfig, tf extractor.Transform) {
+		c.Delivery = &extractor.DeliveryConfig{Transforms: []extractor.Transform{tf}}
+	}},
+}
+
+func TestHeldCandlesWaitForFlush(t *testing.T) {
+	for _, tc := range holdingConfigs {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := gdaxtest.NewServer(nil)
+			defer srv.Close()
+			store, cleanup := newCheckpoint(t)
+			defer cleanup()
+
+			var checkpointed bool
+			tf := &holdAll{onFlush: func() {
+				_, checkpointed, _ = store.Load(product, 60)
+			}}
+			end := testStart.Add(1000 * time.Minute)
+			ext := newExtractor(srv, testStart, end, func(c *extractor.ExtractorConfig) {
+				c.Checkpoint = store
+			})
+			cdls, errs, err := collect(t, context.Background(), ext, func(c *extractor.CollectorConfig) {
+				tc.configure(c, tf)
+			})
+			if err != nil || len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v %v", err, errs)
+			}
+			assertCandles(t, cdls, srv.Candles(product, testStart, end, 60))
+
+			// nothing is delivered until the transform is flushed, so nothing may be checkpointed before
+			if checkpointed {
+				t.Error("expected no checkpoint before the transform was flushed")
+			}
+			cp, ok, err := store.Load(product, 60)
+			if err != nil || !ok || !cp.Equal(end) {
+				t.Errorf("expected a checkpoint at %s once delivered, got %s %t %v", end, cp, ok, err)
+			}
+		})
+	}
+}
+
+func TestHeldCandlesLimited(t *testing.T) {
+	for _, tc := range holdingConfigs {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := gdaxtest.NewServer(nil)
+			defer srv.Close()
+			store, cleanup := newCheckpoint(t)
+			defer cleanup()
+
+			end := testStart.Add(1000 * time.Minute)
+			ext := newExtractor(srv, testStart, end, func(c *extractor.ExtractorConfig) {
+				c.Checkpoint = store
+			})
+			cdls, errs, err := collect(t, context.Background(), ext, func(c *extractor.CollectorConfig) {
+				c.MaxHeld = 10
+				tc.configure(c, &holdAll{onFlush: func() {}})
+			})
+			if err != nil || len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v %v", err, errs)
+			}
+			assertCandles(t, cdls, srv.Candles(product, testStart, end, 60))
+
+			// more candlesticks were held than the limit, so they were released without a checkpoint
+			if cp, ok, err := store.Load(product, 60); ok || err != nil {
+				t.Errorf("expected no checkpoint past the held limit, got %s %v", cp, err)
+			}
+		})
+	}
+}
+
+// failReceiver fails to collect the candlesticks for which fail returns true
+type failReceiver struct {
+	memReceiver
+	fail func(*extractor.Candlestick) bool
+}
+
+func (r *failReceiver) Collect(c *extractor.Candlestick) error {
+	if r.fail(c) {
+		return errors.New("failed to collect")
+	}
+	return r.memReceiver.Collect(c)
+}
+
+func TestFailedCandlesNotCheckpointed(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   extractor.ErrorPolicy
+		expected time.Duration
+	}{
+		// the failed candlestick is in the third range, so only the first two are checkpointed
+		{"reported", extractor.ErrorPolicyReport, 400 * time.Minute},
+		{"dead lettered", extractor.ErrorPolicyDeadLetter, 1000 * time.Minute},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := gdaxtest.NewServer(nil)
+			defer srv.Close()
+			store, cleanup := newCheckpoint(t)
+			defer cleanup()
+
+			failed := testStart.Add(500 * time.Minute).Unix()
+			rcv := &failReceiver{fail: func(c *extractor.Candlestick) bool {
+				return c.Timestamp == failed
+			}}
+			end := testStart.Add(1000 * time.Minute)
+			ext := newExtractor(srv, testStart, end, func(c *extractor.ExtractorConfig) {
+				c.Checkpoint = store
+			})
+			_, errs, _ := collect(t, context.Background(), ext, func(c *extractor.CollectorConfig) {
+				c.Receivers = []extractor.Receiver{rcv}
+				c.Delivery = &extractor.DeliveryConfig{
+					OnError:        tc.policy,
+					DeadLetterFile: filepath.Join(filepath.Dir(store.Path), "dead.ndjson"),
+				}
+			})
+			if len(errs) != 1 {
+				t.Fatalf("expected the failure to be reported, got %v", errs)
+			}
+			cp, ok, err := store.Load(product, 60)
+			if err != nil || !ok || !cp.Equal(testStart.Add(tc.expected)) {
+				t.Errorf("expected a checkpoint at %s, got %s %t %v", testStart.Add(tc.expected), cp, ok, err)
+			}
+		})
+	}
+}
+
+// finishReceiver counts the candlesticks it had when finished, failing to finish with the error
+type finishReceiver struct {
+	memReceiver
+	err      error
+	finished int
+}
+
+func (r *finishReceiver) Finish() error {
+	r.finished = len(r.candles())
+	return r.err
+}
+
+func TestReceiversFinished(t *testing.T) {
+	srv := gdaxtest.NewServer(nil)
+	defer srv.Close()
+
+	finished := &finishReceiver{}
+	failed := &finishReceiver{err: errors.New("failed to write the footer")}
+	end := testStart.Add(400 * time.Minute)
+	cdls, errs, err := collect(t, context.Background(), newExtractor(srv, testStart, end, nil), func(c *extractor.CollectorConfig) {
+		c.Receivers = append(c.Receivers, finished, failed)
+	})
+	if len(cdls) == 0 || finished.finished != len(cdls) || failed.finished != len(cdls) {
+		t.Errorf("expected the receivers to be finished after all %d candlesticks, got %d and %d", len(cdls), finished.finished, failed.finished)
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected the failure to finish to be reported, got %v", errs)
+	}
+	cErr, ok := err.(*extractor.CollectionError)
+	if !ok || len(cErr.Receivers) != 1 || cErr.Receivers[0].Receiver != failed || cErr.Receivers[0].FinishErr == nil {
+		t.Errorf("expected a collection error for the receiver which failed to finish, got %v", err)
+	}
+}
+
+func TestRetryBackoffCancelled(t *testing.T) {
+	srv := gdaxtest.NewServer(nil)
+	defer srv.Close()
+
+	rcv := &failReceiver{fail: func(*extractor.Candlestick) bool { return true }}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	_, _, err := collect(t, ctx, newExtractor(srv, testStart, testStart.Add(1000*time.Minute), nil), func(c *extractor.CollectorConfig) {
+		c.Receivers = []extractor.Receiver{rcv}
+		c.Delivery = &extractor.DeliveryConfig{
+			OnError: extractor.ErrorPolicyRetry,
+			Retry:   &extractor.RetryConfig{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour},
+		}
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %s, got %v", context.DeadlineExceeded, err)
+	}
+	// the hour long backoff is cut short by the cancellation
+	if elapsed := time.Since(began); elapsed > 5*time.Second {
+		t.Errorf("expected the collection to stop once cancelled, took %s", elapsed)
+	}
+}
@@ -0,0 +1,105 @@
+package extractor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Redacted replaces secret values wherever they would otherwise be printed
+const Redacted = "[REDACTED]"
+
+// Credentials authenticate requests to GDAX. they are redacted when formatted, so they can't leak into logs
+type Credentials struct {
+	Key        string `json:"key" yaml:"key"`
+	Secret     string `json:"secret" yaml:"secret"`
+	Passphrase string `json:"passphrase" yaml:"passphrase"`
+}
+
+// String returns the credentials with every value redacted
+func (c Credentials) String() string {
+	return fmt.Sprintf("{Key:%s Secret:%s Passphrase:%s}", Redact(c.Key), Redact(c.Secret), Redact(c.Passphrase))
+}
+
+// GoString redacts the credentials when formatted with %#v
+func (c Credentials) GoString() string {
+	return "extractor.Credentials" + c.String()
+}
+
+// Redact returns Redacted for any non-empty value, so it can be printed without revealing it
+func Redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// LoadCredentialsFile reads credentials from a JSON or YAML (.yaml, .yml) file with `key`, `secret` and
+// `passphrase` fields. the file must not be readable or writable by anyone but its owner (e.g. 0600)
+func LoadCredentialsFile(path string) (*Credentials, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("Credentials file [%s] must only be accessible by its owner, permissions are %#o (expected 0600)", path, perm)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &Credentials{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, creds)
+	default:
+		err = json.Unmarshal(b, creds)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid credentials file [%s]: %s", path, err.Error())
+	}
+	return creds, nil
+}
+
+// ReadSecret reads a secret from the file at path, or from stdin if path is "-". surrounding whitespace,
+// such as a trailing newline, is removed
+func ReadSecret(path string, stdin io.Reader) (string, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = ioutil.ReadAll(stdin)
+	} else {
+		b, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// credentials returns the configured credentials, with any set in Credentials taking precedence over
+// the Key, Secret and Passphrase fields
+func (c *ExtractorConfig) credentials() Credentials {
+	creds := Credentials{Key: c.Key, Secret: c.Secret, Passphrase: c.Passphrase}
+	if c.Credentials == nil {
+		return creds
+	}
+	if c.Credentials.Key != "" {
+		creds.Key = c.Credentials.Key
+	}
+	if c.Credentials.Secret != "" {
+		creds.Secret = c.Credentials.Secret
+	}
+	if c.Credentials.Passphrase != "" {
+		creds.Passphrase = c.Credentials.Passphrase
+	}
+	return creds
+}
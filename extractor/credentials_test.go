@@ -0,0 +1,82 @@
+package extractor_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+)
+
+func TestLoadCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := extractor.Credentials{Key: "key", Secret: "c2VjcmV0: #1", Passphrase: "it's"}
+	cases := []struct {
+		name  string
+		file  string
+		perm  os.FileMode
+		body  string
+		valid bool
+	}{
+		{"json", "creds.json", 0600, `{"key":"key","secret":"c2VjcmV0: #1","passphrase":"it's"}`, true},
+		{"yaml", "creds.yaml", 0600, "---\n# GDAX sandbox\nkey: key\nsecret: \"c2VjcmV0: #1\"\npassphrase: 'it''s' # quoted\n", true},
+		{"yml", "creds.yml", 0600, "key: key\nsecret: 'c2VjcmV0: #1'\npassphrase: \"it's\"\n", true},
+		{"unknown field", "unknown.yaml", 0600, "key: key\nsecrets: secret\n", false},
+		{"invalid yaml", "invalid.yaml", 0600, "key: [key\n", false},
+		{"readable by others", "open.yaml", 0644, "key: key\n", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+			if err := ioutil.WriteFile(path, []byte(tc.body), tc.perm); err != nil {
+				t.Fatal(err)
+			}
+			// the permissions aren't left to the umask
+			if err := os.Chmod(path, tc.perm); err != nil {
+				t.Fatal(err)
+			}
+			creds, err := extractor.LoadCredentialsFile(path)
+			if !tc.valid {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", creds)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *creds != want {
+				// credentials are redacted when formatted, so the fields are compared individually
+				t.Errorf("expected %q %q %q, got %q %q %q", want.Key, want.Secret, want.Passphrase, creds.Key, creds.Secret, creds.Passphrase)
+			}
+		})
+	}
+}
+
+// captureLogger records everything printed to it
+type captureLogger struct {
+	out []string
+}
+
+func (l *captureLogger) Printf(format string, v ...interface{}) {
+	l.out = append(l.out, fmt.Sprintf(format, v...))
+}
+
+func TestDefaultHandlersUseLogger(t *testing.T) {
+	logger := &captureLogger{}
+	col := extractor.NewCollector(&extractor.CollectorConfig{Logger: logger})
+	col.ErrorHandler(errors.New("failed with secret"))
+	col.GapHandler(&extractor.GapEvent{Product: product, Granularity: 60, Start: testStart, End: testStart})
+	if len(logger.out) != 2 || !strings.Contains(logger.out[0], "failed with secret") || !strings.Contains(logger.out[1], product) {
+		t.Errorf("expected the error and gap to be printed by the logger, got %q", logger.out)
+	}
+}
@@ -0,0 +1,392 @@
+package extractor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultDeliveryBufferSize is the number of candlesticks buffered for each receiver if none is configured
+	DefaultDeliveryBufferSize = 100
+	// DefaultBatchSize is the most candlesticks passed to a BatchReceiver at once if none is configured
+	DefaultBatchSize = 500
+	// DefaultBatchLatency is the longest a candlestick waits for its batch to fill if none is configured
+	DefaultBatchLatency = time.Second
+)
+
+// Backpressure is the policy applied when a receiver's buffer is full
+type Backpressure int
+
+const (
+	// BackpressureBlock waits for room in the buffer, slowing collection to the pace of the slowest receiver
+	BackpressureBlock Backpressure = iota
+	// BackpressureDropOldest discards the oldest buffered candlestick to make room for the new one
+	BackpressureDropOldest
+	// BackpressureDropNewest discards the new candlestick, leaving the buffer intact
+	BackpressureDropNewest
+	// BackpressureSpill writes candlesticks to a file on disk until the receiver catches up. nothing is dropped,
+	// and the receiver still gets the candlesticks in order
+	BackpressureSpill
+)
+
+// DeliveryConfig configures how candlesticks are buffered for a single receiver
+type DeliveryConfig struct {
+	// BufferSize is the number of candlesticks held in memory for the receiver. defaults to DefaultDeliveryBufferSize
+	BufferSize int
+	// Backpressure is applied once the buffer is full. defaults to BackpressureBlock
+	Backpressure Backpressure
+	// SpillDir is the directory spill files are created in for BackpressureSpill. defaults to the OS temp directory
+	SpillDir string
+	// BatchSize is the most candlesticks passed to a BatchReceiver at once. defaults to DefaultBatchSize
+	BatchSize int
+	// BatchLatency is the longest a candlestick waits for its batch to fill before a partial batch is passed
+	// to a BatchReceiver. defaults to DefaultBatchLatency
+	BatchLatency time.Duration
+	// OnError is applied when the receiver returns an error. defaults to ErrorPolicyReport
+	OnError ErrorPolicy
+	// Retry configures the attempts and backoff of ErrorPolicyRetry. DefaultRetryConfig is used if nil
+	Retry *RetryConfig
+	// DeadLetterFile is the newline delimited JSON file failed candlesticks are appended to, with their error,
+	// under ErrorPolicyDeadLetter and ErrorPolicyRetry
+	DeadLetterFile string
+	// Transforms are applied in order to each candlestick on the receiver's goroutine, after the collector's
+	// transforms. the instances must not be shared with other receivers
+	Transforms []Transform
+}
+
+// DeliveryStats counts what happened to the candlesticks passed to a receiver
+type DeliveryStats struct {
+	// Delivered is the number of candlesticks collected without error
+	Delivered int64
+	// Dropped is the number of candlesticks discarded because the buffer was full
+	Dropped int64
+	// Failed is the number of candlesticks the receiver returned an error for
+	Failed int64
+	// Spilled is the number of candlesticks written to disk because the buffer was full. they are still delivered
+	Spilled int64
+	// Retried is the number of retries made under ErrorPolicyRetry
+	Retried int64
+	// DeadLettered is the number of failed candlesticks written to the dead letter file
+	DeadLettered int64
+}
+
+// ReceiverStats is the delivery stats of a single receiver
+type ReceiverStats struct {
+	Receiver Receiver
+	DeliveryStats
+}
+
+// envelope carries a candlestick through a delivery buffer, with the sequence it was collected in
+type envelope struct {
+	Seq         uint64       `json:"seq"`
+	Candlestick *Candlestick `json:"candlestick"`
+}
+
+// delivery buffers candlesticks for a receiver, which collects them on its own goroutine
+type delivery struct {
+	receiver Receiver
+	config   DeliveryConfig
+	stats    DeliveryStats
+	mutex    *sync.Mutex
+	cond     *sync.Cond
+	buf      []*envelope
+	spill    *spillFile
+	closed   bool
+	aborted  bool
+	firstErr error
+	// finishErr is returned by the receiver's Finish, if it implements Finisher
+	finishErr error
+	// deadLetters is opened on the first dead letter
+	deadLetters *os.File
+	// holds is set when the receiver's transforms hold candlesticks back. the sequences they were passed
+	// are held until the transforms are flushed, and lost is set if anything since wasn't delivered. both
+	// are only used on the receiver's goroutine
+	holds bool
+	held  []uint64
+	lost  bool
+}
+
+func newDelivery(rcv Receiver, config *DeliveryConfig) *delivery {
+	d := &delivery{
+		receiver: rcv,
+		mutex:    &sync.Mutex{},
+	}
+	if config != nil {
+		d.config = *config
+	}
+	if d.config.BufferSize <= 0 {
+		d.config.BufferSize = DefaultDeliveryBufferSize
+	}
+	if d.config.BatchSize <= 0 {
+		d.config.BatchSize = DefaultBatchSize
+	}
+	if d.config.BatchLatency <= 0 {
+		d.config.BatchLatency = DefaultBatchLatency
+	}
+	d.holds = Chain(d.config.Transforms).HoldsBack()
+	d.cond = sync.NewCond(d.mutex)
+	return d
+}
+
+// push adds the candlestick to the buffer, applying the backpressure policy if it's full. the envelope
+// dropped to make room, if any, is returned
+func (d *delivery) push(env *envelope) (*envelope, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	defer d.cond.Broadcast()
+
+	// candlesticks are discarded once the collection is aborted
+	if d.aborted {
+		return nil, nil
+	}
+
+	full := len(d.buf) >= d.config.BufferSize
+	switch d.config.Backpressure {
+	case BackpressureDropOldest:
+		if full {
+			dropped := d.buf[0]
+			d.buf = append(d.buf[1:], env)
+			atomic.AddInt64(&d.stats.Dropped, 1)
+			return dropped, nil
+		}
+	case BackpressureDropNewest:
+		if full {
+			atomic.AddInt64(&d.stats.Dropped, 1)
+			return env, nil
+		}
+	case BackpressureSpill:
+		// once spilling, everything goes to disk until it's drained, to keep the candlesticks in order
+		if full || d.spill.pending() > 0 {
+			if d.spill == nil {
+				s, err := newSpillFile(d.config.SpillDir)
+				if err != nil {
+					atomic.AddInt64(&d.stats.Dropped, 1)
+					return env, err
+				}
+				d.spill = s
+			}
+			if err := d.spill.write(env); err != nil {
+				atomic.AddInt64(&d.stats.Dropped, 1)
+				return env, err
+			}
+			atomic.AddInt64(&d.stats.Spilled, 1)
+			return nil, nil
+		}
+	default:
+		for len(d.buf) >= d.config.BufferSize && !d.closed {
+			d.cond.Wait()
+		}
+		// the wait may have been ended by an abort, which cleared the buffer
+		if d.aborted {
+			return nil, nil
+		}
+	}
+	d.buf = append(d.buf, env)
+	return nil, nil
+}
+
+// pop waits for the next candlestick, until the deadline if one is set. false is returned once the
+// delivery is closed and drained. no candlestick is returned if the deadline passes first
+func (d *delivery) pop(deadline time.Time) (*envelope, bool, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !deadline.IsZero() {
+		t := time.AfterFunc(time.Until(deadline), func() {
+			d.mutex.Lock()
+			d.cond.Broadcast()
+			d.mutex.Unlock()
+		})
+		defer t.Stop()
+	}
+	for len(d.buf) == 0 && d.spill.pending() == 0 && !d.closed {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil, true, nil
+		}
+		d.cond.Wait()
+	}
+	defer d.cond.Broadcast()
+
+	if d.aborted {
+		return nil, false, nil
+	}
+	if len(d.buf) > 0 {
+		env := d.buf[0]
+		d.buf[0] = nil
+		d.buf = d.buf[1:]
+		return env, true, nil
+	}
+	if d.spill.pending() > 0 {
+		env, err := d.spill.read()
+		return env, true, err
+	}
+	return nil, false, nil
+}
+
+// close stops the delivery once the buffer is drained
+func (d *delivery) close() {
+	d.mutex.Lock()
+	d.closed = true
+	d.mutex.Unlock()
+	d.cond.Broadcast()
+}
+
+// abort discards the buffered candlesticks and stops the delivery
+func (d *delivery) abort() {
+	d.mutex.Lock()
+	d.aborted = true
+	d.closed = true
+	d.buf = nil
+	d.mutex.Unlock()
+	d.cond.Broadcast()
+}
+
+func (d *delivery) isAborted() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.aborted
+}
+
+// recordError keeps the first error returned by the receiver, for the collection error
+func (d *delivery) recordError(err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.firstErr == nil {
+		d.firstErr = err
+	}
+}
+
+func (d *delivery) firstError() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.firstErr
+}
+
+func (d *delivery) recordFinishError(err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.finishErr = err
+}
+
+func (d *delivery) finishError() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.finishErr
+}
+
+// closeDeadLetters closes the dead letter file, if one was opened
+func (d *delivery) closeDeadLetters() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.deadLetters == nil {
+		return nil
+	}
+	err := d.deadLetters.Close()
+	d.deadLetters = nil
+	return err
+}
+
+// removeSpill deletes the spill file, if one was created
+func (d *delivery) removeSpill() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.spill == nil {
+		return nil
+	}
+	err := d.spill.remove()
+	d.spill = nil
+	return err
+}
+
+func (d *delivery) snapshot() ReceiverStats {
+	return ReceiverStats{
+		Receiver: d.receiver,
+		DeliveryStats: DeliveryStats{
+			Delivered:    atomic.LoadInt64(&d.stats.Delivered),
+			Dropped:      atomic.LoadInt64(&d.stats.Dropped),
+			Failed:       atomic.LoadInt64(&d.stats.Failed),
+			Spilled:      atomic.LoadInt64(&d.stats.Spilled),
+			Retried:      atomic.LoadInt64(&d.stats.Retried),
+			DeadLettered: atomic.LoadInt64(&d.stats.DeadLettered),
+		},
+	}
+}
+
+// spillFile is a newline delimited JSON queue on disk. it is truncated whenever it's drained
+type spillFile struct {
+	file     *os.File
+	reader   *bufio.Reader
+	written  int
+	consumed int
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	f, err := ioutil.TempFile(dir, "gdax-extractor-spill-")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create spill file: %s", err.Error())
+	}
+	return &spillFile{file: f}, nil
+}
+
+func (s *spillFile) pending() int {
+	if s == nil {
+		return 0
+	}
+	return s.written - s.consumed
+}
+
+func (s *spillFile) write(env *envelope) error {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if _, err = s.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err = s.file.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("Failed to write spill file [%s]: %s", s.file.Name(), err.Error())
+	}
+	s.written++
+	return nil
+}
+
+func (s *spillFile) read() (*envelope, error) {
+	if s.reader == nil {
+		// read at offsets independently of the end the file is written at
+		s.reader = bufio.NewReader(io.NewSectionReader(s.file, 0, math.MaxInt64))
+	}
+	line, err := s.reader.ReadBytes('\n')
+	s.consumed++
+	if s.pending() == 0 {
+		if tErr := s.reset(); err == nil {
+			err = tErr
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read spill file [%s]: %s", s.file.Name(), err.Error())
+	}
+	env := &envelope{}
+	if err = json.Unmarshal(line, env); err != nil {
+		return nil, fmt.Errorf("Invalid spill file entry [%s]: %s", s.file.Name(), err.Error())
+	}
+	return env, nil
+}
+
+// reset truncates the drained file so it doesn't grow for the whole collection
+func (s *spillFile) reset() error {
+	s.written, s.consumed = 0, 0
+	s.reader = nil
+	return s.file.Truncate(0)
+}
+
+func (s *spillFile) remove() error {
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
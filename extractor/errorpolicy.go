@@ -0,0 +1,190 @@
+package extractor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorPolicy is applied when a receiver returns an error for a candlestick
+type ErrorPolicy int
+
+const (
+	// ErrorPolicyReport passes the error to the error handler and moves on to the next candlestick
+	ErrorPolicyReport ErrorPolicy = iota
+	// ErrorPolicyFailFast aborts the whole collection, stopping the extractor and every receiver
+	ErrorPolicyFailFast
+	// ErrorPolicyRetry retries the candlestick with backoff. once the attempts are exhausted, the candlestick
+	// is written to the dead letter file if one is set, otherwise the error is reported
+	ErrorPolicyRetry
+	// ErrorPolicyDeadLetter writes the candlestick and error to the dead letter file
+	ErrorPolicyDeadLetter
+)
+
+// CollectionError is returned by Collect when any receiver failed to collect candlesticks
+type CollectionError struct {
+	// Aborted is the error which aborted the collection under ErrorPolicyFailFast, if any
+	Aborted error
+	// Receivers is the stats and first error of each receiver which failed
+	Receivers []ReceiverFailure
+}
+
+// ReceiverFailure summarizes the failures of a single receiver
+type ReceiverFailure struct {
+	ReceiverStats
+	// Err is the first error the receiver returned
+	Err error
+	// FinishErr is the error returned by Finish, for a receiver implementing Finisher
+	FinishErr error
+}
+
+func (e *CollectionError) Error() string {
+	var parts []string
+	if e.Aborted != nil {
+		parts = append(parts, fmt.Sprintf("aborted: %s", e.Aborted.Error()))
+	}
+	for _, f := range e.Receivers {
+		msg := fmt.Sprintf("%T failed %d candlesticks", f.Receiver, f.Failed)
+		if f.DeadLettered > 0 {
+			msg += fmt.Sprintf(" (%d dead lettered)", f.DeadLettered)
+		}
+		if f.Err != nil {
+			msg += fmt.Sprintf(", first: %s", f.Err.Error())
+		}
+		if f.FinishErr != nil {
+			msg += fmt.Sprintf(", %s", f.FinishErr.Error())
+		}
+		parts = append(parts, msg)
+	}
+	return "Collection Error: " + strings.Join(parts, "; ")
+}
+
+// deadLetter is a line of the dead letter file
+type deadLetter struct {
+	Time        string       `json:"time"`
+	Receiver    string       `json:"receiver"`
+	Error       string       `json:"error"`
+	Candlestick *Candlestick `json:"candlestick"`
+}
+
+// attempt collects with the receiver, retrying with backoff under ErrorPolicyRetry. the context error is
+// returned if the collection is cancelled while waiting to retry
+func (c *Collector) attempt(d *delivery, collect func() error) error {
+	err := collect()
+	if err == nil || d.config.OnError != ErrorPolicyRetry {
+		return err
+	}
+
+	retry := d.config.Retry
+	if retry == nil {
+		retry = DefaultRetryConfig()
+	}
+	for attempt := 1; attempt < retry.MaxAttempts && !d.isAborted(); attempt++ {
+		atomic.AddInt64(&d.stats.Retried, 1)
+		if sErr := sleep(c.context(), retry.backoff(attempt)); sErr != nil {
+			return sErr
+		}
+		if err = collect(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// fail applies the error policy of the receiver to the candlesticks it failed to collect. true is returned
+// if they were written to the dead letter file, in which case they're acknowledged as delivered
+func (c *Collector) fail(d *delivery, cdls []*Candlestick, err error) bool {
+	atomic.AddInt64(&d.stats.Failed, int64(len(cdls)))
+	d.recordError(err)
+
+	deadLettered := false
+	switch {
+	case d.config.OnError == ErrorPolicyFailFast:
+		c.abort(err)
+		return false
+	case d.config.OnError == ErrorPolicyDeadLetter,
+		d.config.OnError == ErrorPolicyRetry && d.config.DeadLetterFile != "":
+		if dlErr := d.writeDeadLetters(cdls, err); dlErr != nil {
+			c.handleError(dlErr)
+		} else {
+			deadLettered = true
+		}
+	}
+	c.handleError(err)
+	return deadLettered
+}
+
+// abort stops the extractor and every receiver, on the first error under ErrorPolicyFailFast
+func (c *Collector) abort(err error) {
+	c.mutex.Lock()
+	if c.aborted != nil {
+		c.mutex.Unlock()
+		return
+	}
+	c.aborted = err
+	deliveries := c.deliveries
+	c.mutex.Unlock()
+
+	c.handleError(err)
+	c.Extractor.Stop()
+	for _, d := range deliveries {
+		d.abort()
+	}
+}
+
+// collectionError summarizes the failures of the collection, if there were any
+func (c *Collector) collectionError() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cErr := &CollectionError{Aborted: c.aborted}
+	for _, d := range c.deliveries {
+		stats := d.snapshot()
+		finishErr := d.finishError()
+		if stats.Failed > 0 || finishErr != nil {
+			cErr.Receivers = append(cErr.Receivers, ReceiverFailure{ReceiverStats: stats, Err: d.firstError(), FinishErr: finishErr})
+		}
+	}
+	if cErr.Aborted == nil && len(cErr.Receivers) == 0 {
+		return nil
+	}
+	return cErr
+}
+
+// writeDeadLetters appends the candlesticks and error to the dead letter file as newline delimited JSON
+func (d *delivery) writeDeadLetters(cdls []*Candlestick, err error) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.config.DeadLetterFile == "" {
+		return fmt.Errorf("No dead letter file set for %T", d.receiver)
+	}
+	if d.deadLetters == nil {
+		f, oErr := os.OpenFile(d.config.DeadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if oErr != nil {
+			return oErr
+		}
+		d.deadLetters = f
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	var lines []byte
+	for _, cdl := range cdls {
+		b, mErr := json.Marshal(deadLetter{
+			Time:        now,
+			Receiver:    fmt.Sprintf("%T", d.receiver),
+			Error:       err.Error(),
+			Candlestick: cdl,
+		})
+		if mErr != nil {
+			return mErr
+		}
+		lines = append(append(lines, b...), '\n')
+	}
+	if _, wErr := d.deadLetters.Write(lines); wErr != nil {
+		return fmt.Errorf("Failed to write dead letter file [%s]: %s", d.config.DeadLetterFile, wErr.Error())
+	}
+	atomic.AddInt64(&d.stats.DeadLettered, int64(len(cdls)))
+	return nil
+}
@@ -1,8 +1,12 @@
 package extractor
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	exchange "github.com/preichenberger/go-coinbase-exchange"
@@ -10,106 +14,218 @@ import (
 
 // Extractor encapsulates the extracting configuration and process
 type Extractor struct {
+	// Client is the GDAX client of the default source. it is nil if a source was provided
 	Client          *exchange.Client
+	Source          Source
 	Config          *ExtractorConfig
 	Logger          Logger
+	RateLimiter     RateLimiter
 	CandlestickChan chan *Candlestick
 	ErrorChan       chan error
+	GapChan         chan *GapEvent
+	mutex           *sync.Mutex
 	running         bool
+	closed          bool
+	cancel          context.CancelFunc
+	done            chan struct{}
+	err             error
+	fetchG          int
+	pending         map[string][]*pendingRange
 }
 
 // ExtractorConfig provides values for the extractor-GDAX request configuration
 type ExtractorConfig struct {
+	// Key, Secret and Passphrase authenticate GDAX requests. they are optional, requests are unsigned if all are empty
 	Key        string
 	Secret     string
 	Passphrase string
-	Logger     Logger
-	BufferSize int
+	// Credentials, e.g. from LoadCredentialsFile, take precedence over Key, Secret and Passphrase where set
+	Credentials *Credentials
+	Logger      Logger
+	BufferSize  int
+	// HTTPClient is used for every request to GDAX. a client with a 30 second timeout is used if nil
+	HTTPClient *http.Client
+	// BaseURL overrides the GDAX API URL, e.g. to use the sandbox or a gdaxtest server
+	BaseURL string
+	// Source provides the candlesticks. a GDAX source is built from the credentials and http client if nil
+	Source Source
+	// Retry configures how failed range requests are retried. DefaultRetryConfig is used if nil
+	Retry *RetryConfig
+	// RateLimiter gates every request to the exchange. pass the same limiter to multiple extractors
+	// to share a limit between them. if nil, a limiter shared by the whole process is used
+	RateLimiter RateLimiter
+	// Checkpoint records the progress of the extraction as candlesticks are acknowledged by the collector
+	Checkpoint CheckpointStore
+	// Resume continues the extraction from the range following the saved checkpoint, if there is one
+	Resume bool
+	// DetectGaps compares each range to the candlesticks expected at the granularity, and reports
+	// any missing on the gap channel. the gap channel must be read if set, as the collector does
+	DetectGaps bool
+	// RefetchGaps requests missing candlesticks again before they are reported. implies DetectGaps
+	RefetchGaps bool
+	// Order of the candlesticks sent for each product. defaults to OrderAscending
+	Order      Order
 	Extraction *ExtractionConfig
 }
 
 // ExtractionConfig providesvalues for the actual extracting execution
 type ExtractionConfig struct {
-	Product     string
+	Product string
+	// Products extracts each of the products concurrently, sharing the rate limiter. Product is
+	// ignored if set
+	Products    []string
 	Start       time.Time
 	End         time.Time
 	Granularity int
+	// Aggregate allows granularities the exchange does not serve, such as 7200 or 604800 (weekly). the
+	// largest supported granularity which divides it is fetched and aggregated
+	Aggregate bool
 }
 
 // New builds an initialized extractor
 func New(config *ExtractorConfig) *Extractor {
-	client := exchange.NewClient(config.Secret, config.Key, config.Passphrase)
+	var client *exchange.Client
+	if config.Source == nil {
+		creds := config.credentials()
+		gdax := NewGDAXSource(creds.Secret, creds.Key, creds.Passphrase, config.HTTPClient)
+		if config.BaseURL != "" {
+			gdax.Client.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+		}
+		client = gdax.Client
+		config.Source = gdax
+	}
+	if config.Retry == nil {
+		config.Retry = DefaultRetryConfig()
+	}
+	if config.RateLimiter == nil {
+		config.RateLimiter = defaultLimiter
+	}
 	return &Extractor{
 		Client:          client,
+		Source:          config.Source,
 		Config:          config,
 		Logger:          config.Logger,
+		RateLimiter:     config.RateLimiter,
 		CandlestickChan: make(chan *Candlestick, config.BufferSize),
 		ErrorChan:       make(chan error, config.BufferSize),
+		GapChan:         make(chan *GapEvent, config.BufferSize),
+		mutex:           &sync.Mutex{},
 	}
 }
 
 // Start gets trade history and writes each result to the channels. extraction buckets are split by `nil`
 func (m *Extractor) Start() error {
-	if m.running == true {
+	return m.StartContext(context.Background())
+}
+
+// StartContext begins extraction in the background, as Start does. Cancelling the context aborts the
+// in-flight request, ends extraction, and closes the channels. Wait returns the context error
+func (m *Extractor) StartContext(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.running {
 		return errors.New("Extractor already started")
 	}
-	rngs := buildReqRanges(m.Config.Extraction)
-
-	// sleep time is used to wait between requests and evade ratelimiting
-	waitMin := 400 * time.Millisecond
-
-	// Make a request every .4 seconds(to avoid ratelimiting). pipe the output to the collectors
-	m.running = true
-	go func() {
-		for _, rng := range rngs {
-			if !m.running {
-				break
-			}
-			start := rng[0]
-			end := rng[1]
-			started := time.Now()
-
-			// Make req
-			cdls, err := m.GetCandleRange(m.Config.Extraction.Product, start, end, m.Config.Extraction.Granularity)
-			if err != nil {
-				m.ErrorChan <- err
-			}
-
-			// Log if set
-			if m.Config.Logger != nil {
-				tRng := fmt.Sprintf("(%s - %s)", start.String(), end.String())
-				tDif := end.Sub(start).String()
-				m.Logger.Printf("\n=> REQ: [%s:%d] %s=%s\n<= RES: %d results\n", m.Config.Extraction.Product, m.Config.Extraction.Granularity, tRng, tDif, len(cdls))
+	if m.closed {
+		return errors.New("Extractor channels are closed, Reset must be called before restarting")
+	}
+	if err := m.Config.Extraction.Validate(m.Source.Granularities()); err != nil {
+		return err
+	}
+	if gdax, ok := m.Source.(*GDAXSource); ok {
+		if err := gdax.checkCredentials(); err != nil {
+			return err
+		}
+	}
+	if m.Config.Order == OrderDescending && m.Config.Checkpoint != nil {
+		return errors.New("Descending extractions can't be checkpointed")
+	}
+	products := m.Config.Extraction.products()
+	m.fetchG, _ = m.Config.Extraction.fetchGranularity(m.Source.Granularities())
+	rngs := make([][][]time.Time, len(products))
+	delivered := make([]time.Time, len(products))
+	for i, product := range products {
+		r, d, err := m.resumeRanges(product)
+		if err != nil {
+			return err
+		}
+		delivered[i] = d
+		if m.Config.Order == OrderDescending {
+			for a, b := 0, len(r)-1; a < b; a, b = a+1, b-1 {
+				r[a], r[b] = r[b], r[a]
 			}
+		}
+		rngs[i] = r
+	}
 
-			// Send results
-			for _, cdl := range cdls {
-				func(candle Candlestick) {
-					m.CandlestickChan <- &candle
-				}(cdl)
-			}
+	runCtx, cancel := context.WithCancel(ctx)
+	m.running = true
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	m.err = nil
+	m.pending = map[string][]*pendingRange{}
 
-			// sleep until we reached an acceptable rate according to the GDAX API
-			time.Sleep(waitMin - (time.Since(started) / time.Millisecond))
-		}
-		if m.running {
-			m.Stop()
+	// Make a request whenever the rate limiter allows it. pipe the output to the collectors
+	go func() {
+		defer close(m.done)
+		var wg sync.WaitGroup
+		for i, product := range products {
+			wg.Add(1)
+			go func(product string, rngs [][]time.Time, delivered time.Time) {
+				defer wg.Done()
+				m.run(runCtx, product, rngs, delivered)
+			}(product, rngs[i], delivered[i])
 		}
+		wg.Wait()
+		cancel()
+
+		m.mutex.Lock()
+		m.running = false
+		m.err = ctx.Err()
+		m.mutex.Unlock()
+		m.closeChannels()
 	}()
 	return nil
 }
 
-// Stop closed the channels and snds the extraction process
+// Wait blocks until a started extraction ends. the error of the context passed to StartContext is
+// returned if it was cancelled, Stop is not considered an error
+func (m *Extractor) Wait() error {
+	m.mutex.Lock()
+	done := m.done
+	m.mutex.Unlock()
+	if done == nil {
+		return nil
+	}
+	<-done
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.err
+}
+
+// Stop ends the extraction process. the channels are closed once the running extraction exits, or
+// immediately if it was never started
 func (m *Extractor) Stop() {
-	m.running = false
-	close(m.CandlestickChan)
-	close(m.ErrorChan)
+	m.mutex.Lock()
+	cancel := m.cancel
+	m.mutex.Unlock()
+	if cancel != nil {
+		cancel()
+		return
+	}
+	m.closeChannels()
 }
 
-// Reset overwrites the old channels with new channels
+// Reset overwrites the old channels with new channels. it should not be called while running
 func (m *Extractor) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.closed = false
+	m.cancel = nil
 	m.CandlestickChan = make(chan *Candlestick, m.Config.BufferSize)
 	m.ErrorChan = make(chan error, m.Config.BufferSize)
+	m.GapChan = make(chan *GapEvent, m.Config.BufferSize)
 }
 
 // Candlesticks returns the candlestick channel
@@ -122,34 +238,265 @@ func (m *Extractor) Errors() chan error {
 	return m.ErrorChan
 }
 
-// GetCandleRange returns a set of cnadlestick structs from the exchange for the product, range, and granularity
+// GetCandleRange returns a set of cnadlestick structs from the source for the product, range, and granularity.
+// failures are returned as a *RangeError
 func (m *Extractor) GetCandleRange(product string, start time.Time, end time.Time, granularity int) ([]Candlestick, error) {
-	var cdls []Candlestick
-	params := exchange.GetHistoricRatesParams{
-		Start:       start,
-		End:         end,
-		Granularity: granularity,
-	}
+	return m.GetCandleRangeContext(context.Background(), product, start, end, granularity)
+}
 
-	rts, err := m.Client.GetHistoricRates(product, params)
+// GetCandleRangeContext is GetCandleRange, aborting the request if the context is cancelled
+func (m *Extractor) GetCandleRangeContext(ctx context.Context, product string, start time.Time, end time.Time, granularity int) ([]Candlestick, error) {
+	cdls, err := m.Source.GetCandles(ctx, product, start, end, granularity)
 	if err != nil {
-		return cdls, fmt.Errorf("GDAX Request Error: [%s] %s", product, err.Error())
+		rErr := &RangeError{
+			Source:      m.Source.Name(),
+			Product:     product,
+			Start:       start,
+			End:         end,
+			Granularity: granularity,
+			Attempts:    1,
+			Err:         err,
+		}
+		if sErr, ok := err.(*StatusError); ok {
+			rErr.StatusCode = sErr.StatusCode
+			rErr.RetryAfter = sErr.RetryAfter
+			rErr.Err = sErr.Err
+		}
+		return []Candlestick{}, rErr
 	}
 
-	return CandlesFromRates(granularity, rts), nil
+	for i := range cdls {
+		if cdls[i].Source == "" {
+			cdls[i].Source = m.Source.Name()
+		}
+	}
+	return cdls, nil
 }
 
 //
 // Internal helpers
 //
 
-// buildReqRanges takes the extracting config and breaks it into 200 result-request blocks
-// To maintain compliance with the bounds of the GDAX API
-func buildReqRanges(config *ExtractionConfig) [][]time.Time {
+// run requests each range of the product in turn, sending the results to the channels until done or cancelled.
+// candlesticks at or before delivered, if set, were delivered by a previous extraction and aren't sent again
+func (m *Extractor) run(ctx context.Context, product string, rngs [][]time.Time, delivered time.Time) {
+	granularity := m.fetchG
+	ord := &orderer{order: m.Config.Order}
+	if !delivered.IsZero() {
+		ord.last = delivered.Unix()
+		ord.sent = true
+	}
+	for _, rng := range rngs {
+		if ctx.Err() != nil {
+			return
+		}
+		start := rng[0]
+		end := rng[1]
+
+		// Make req, retrying transient failures so the range isn't lost
+		cdls, err := m.getCandleRangeWithRetry(ctx, product, start, end, granularity)
+		if err != nil {
+			// a cancelled request is not an extraction failure
+			if ctx.Err() != nil {
+				return
+			}
+			if !m.sendError(ctx, err) {
+				return
+			}
+		} else if m.Config.DetectGaps || m.Config.RefetchGaps {
+			var gap *GapEvent
+			cdls, gap = m.checkGaps(ctx, product, start, end, granularity, cdls)
+			if ctx.Err() != nil {
+				return
+			}
+			if gap != nil {
+				select {
+				case m.GapChan <- gap:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		// Combine into the requested granularity if the exchange doesn't serve it
+		if granularity != m.Config.Extraction.Granularity {
+			cdls = aggregateCandles(cdls, start, end, m.Config.Extraction.Granularity)
+		}
+
+		// Sort and remove the candlestick shared with the previous range
+		cdls = ord.apply(cdls)
+
+		// Track the range before sending, so acknowledgements can't arrive ahead of it
+		if err := m.trackRange(product, end, cdls, err != nil); err != nil {
+			if !m.sendError(ctx, err) {
+				return
+			}
+		}
+
+		// Log if set
+		if m.Config.Logger != nil {
+			tRng := fmt.Sprintf("(%s - %s)", start.String(), end.String())
+			tDif := end.Sub(start).String()
+			m.Logger.Printf("\n=> REQ: [%s:%d] %s=%s\n<= RES: %d results\n", product, granularity, tRng, tDif, len(cdls))
+		}
+
+		// Send results
+		for i := range cdls {
+			select {
+			case m.CandlestickChan <- &cdls[i]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// sendError pushes the error to the error channel, returning false if the context was cancelled first
+func (m *Extractor) sendError(ctx context.Context, err error) bool {
+	select {
+	case m.ErrorChan <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// resumeRanges builds the request ranges for the product, starting after the saved checkpoint if resuming.
+// when resuming, the time at or before which candlesticks were delivered by the previous extraction is
+// also returned
+func (m *Extractor) resumeRanges(product string) ([][]time.Time, time.Time, error) {
+	ext := m.Config.Extraction
+	if ext.Granularity != m.fetchG {
+		// start at the beginning of the first aggregated bucket, so it isn't partially filled
+		aligned := *ext
+		aligned.Start = alignTime(ext.Start, ext.Granularity)
+		ext = &aligned
+	}
+	if !m.Config.Resume || m.Config.Checkpoint == nil {
+		return buildReqRanges(ext, m.fetchG, m.Source.MaxCandles()), time.Time{}, nil
+	}
+
+	cp, ok, err := m.Config.Checkpoint.Load(product, ext.Granularity)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if !ok || !cp.After(ext.Start) {
+		return buildReqRanges(ext, m.fetchG, m.Source.MaxCandles()), time.Time{}, nil
+	}
+	if !cp.Before(ext.End) {
+		// the extraction already completed
+		return [][]time.Time{}, time.Time{}, nil
+	}
+
+	if m.Config.Logger != nil {
+		m.Logger.Printf("\n=> RESUME: [%s:%d] from %s\n", product, ext.Granularity, cp.String())
+	}
+	resumed := *ext
+	resumed.Start = cp
+
+	// the exchange includes the candlestick at the end of a range, so the one at the checkpoint was
+	// delivered with the range it ends. aggregated buckets end before the range does, so the bucket
+	// at the checkpoint is yet to be delivered
+	delivered := cp
+	if ext.Granularity != m.fetchG {
+		delivered = cp.Add(-time.Second)
+	}
+	return buildReqRanges(&resumed, m.fetchG, m.Source.MaxCandles()), delivered, nil
+}
+
+// closeChannels closes the candlestick and error channels if they are not already closed
+func (m *Extractor) closeChannels() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.closed {
+		return
+	}
+	m.closed = true
+	close(m.CandlestickChan)
+	close(m.ErrorChan)
+	close(m.GapChan)
+}
+
+// getCandleRangeWithRetry wraps GetCandleRangeContext, retrying transient failures according to the retry config
+func (m *Extractor) getCandleRangeWithRetry(ctx context.Context, product string, start time.Time, end time.Time, granularity int) ([]Candlestick, error) {
+	retry := m.Config.Retry
+	for attempt := 1; ; attempt++ {
+		if err := m.RateLimiter.Wait(ctx); err != nil {
+			return []Candlestick{}, err
+		}
+		cdls, err := m.GetCandleRangeContext(ctx, product, start, end, granularity)
+		if err == nil {
+			return cdls, nil
+		}
+
+		rErr, ok := err.(*RangeError)
+		if !ok {
+			return cdls, err
+		}
+		rErr.Attempts = attempt
+		if rErr.RetryAfter > 0 {
+			// hold every request sharing the limiter until the exchange is ready
+			m.RateLimiter.Backoff(rErr.RetryAfter)
+		}
+		if attempt >= retry.MaxAttempts || !retry.retryable(rErr) || ctx.Err() != nil {
+			return cdls, rErr
+		}
+
+		wait := retry.backoff(attempt)
+		if m.Config.Logger != nil {
+			m.Logger.Printf("\n=> RETRY: [%s:%d] attempt %d failed (%s), retrying in %s\n", product, granularity, attempt, rErr.Err.Error(), wait.String())
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return cdls, rErr
+		}
+	}
+}
+
+// sleep waits for the duration, returning early with the context error if it is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// products returns the list of products to extract, removing duplicates
+func (c *ExtractionConfig) products() []string {
+	ps := c.Products
+	if len(ps) == 0 && c.Product != "" {
+		ps = []string{c.Product}
+	}
+
+	var uniq []string
+	seen := map[string]bool{}
+	for _, p := range ps {
+		if p != "" && !seen[p] {
+			seen[p] = true
+			uniq = append(uniq, p)
+		}
+	}
+	return uniq
+}
+
+// buildReqRanges takes the extracting config and breaks it into blocks of max candle requests at the fetched
+// granularity To maintain compliance with the bounds of the source API
+func buildReqRanges(config *ExtractionConfig, fetchGranularity int, maxCandles int) [][]time.Time {
 	var bs [][]time.Time
 
-	// deterextract the time frame for each request
-	frame := time.Duration(config.Granularity*200) * time.Second
+	// deterextract the time frame for each request. when aggregating, the frame is a whole number of
+	// buckets, so no bucket is split between requests
+	secs := fetchGranularity * maxCandles
+	if config.Granularity > fetchGranularity {
+		secs -= secs % config.Granularity
+	}
+	if secs <= 0 {
+		return [][]time.Time{{config.Start, config.End}}
+	}
+	frame := time.Duration(secs) * time.Second
 
 	s := config.Start
 	e := s.Add(frame)
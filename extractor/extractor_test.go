@@ -0,0 +1,348 @@
+package extractor_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/gdaxtest"
+)
+
+const product = "BTC-USD"
+
+// testStart is a fixed, minute aligned time well in the past, so every candlestick of a test range has closed
+var testStart = time.Date(2017, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+// memReceiver collects candlesticks in memory
+type memReceiver struct {
+	mutex sync.Mutex
+	cdls  []*extractor.Candlestick
+}
+
+func (r *memReceiver) Collect(c *extractor.Candlestick) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cdls = append(r.cdls, c)
+	return nil
+}
+
+func (r *memReceiver) Close() {}
+
+func (r *memReceiver) candles() []*extractor.Candlestick {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*extractor.Candlestick{}, r.cdls...)
+}
+
+// newExtractor builds an extractor against the mock server, with fast retries and no rate limit
+func newExtractor(srv *gdaxtest.Server, start time.Time, end time.Time, configure func(*extractor.ExtractorConfig)) *extractor.Extractor {
+	config := &extractor.ExtractorConfig{
+		BaseURL:     srv.URL,
+		HTTPClient:  &http.Client{Timeout: 5 * time.Second},
+		RateLimiter: extractor.NewTokenBucket(1000, 100),
+		Retry: &extractor.RetryConfig{
+			MaxAttempts:       3,
+			BaseDelay:         time.Millisecond,
+			MaxDelay:          10 * time.Millisecond,
+			RetryableStatuses: []int{429, 500},
+		},
+		Extraction: &extractor.ExtractionConfig{
+			Product:     product,
+			Start:       start,
+			End:         end,
+			Granularity: 60,
+		},
+	}
+	if configure != nil {
+		configure(config)
+	}
+	return extractor.New(config)
+}
+
+// collect runs the extraction through a collector to an in-memory receiver, returning the candlesticks,
+// the errors passed to the error handler and the result of the collection
+func collect(t *testing.T, ctx context.Context, ext *extractor.Extractor, configure func(*extractor.CollectorConfig)) ([]*extractor.Candlestick, []error, error) {
+	rcv := &memReceiver{}
+	var errMutex sync.Mutex
+	var errs []error
+	config := &extractor.CollectorConfig{
+		Extractor: ext,
+		Receivers: []extractor.Receiver{rcv},
+		ErrorHandler: func(err error) {
+			errMutex.Lock()
+			defer errMutex.Unlock()
+			errs = append(errs, err)
+		},
+	}
+	if configure != nil {
+		configure(config)
+	}
+	col := extractor.NewCollector(config)
+
+	if err := ext.StartContext(ctx); err != nil {
+		t.Fatalf("failed to start extraction: %s", err)
+	}
+	err := col.CollectContext(ctx)
+	if wErr := ext.Wait(); wErr != nil && err == nil {
+		err = wErr
+	}
+	errMutex.Lock()
+	defer errMutex.Unlock()
+	return rcv.candles(), errs, err
+}
+
+// assertCandles fails unless the collected candlesticks are exactly the expected ones, in order
+func assertCandles(t *testing.T, got []*extractor.Candlestick, want []extractor.Candlestick) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d candlesticks, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if !sameCandle(got[i], &want[i]) {
+			t.Fatalf("candlestick %d: expected %+v, got %+v", i, want[i], *got[i])
+		}
+	}
+}
+
+func sameCandle(a *extractor.Candlestick, b *extractor.Candlestick) bool {
+	return a.Product == b.Product && a.Granularity == b.Granularity && a.Timestamp == b.Timestamp &&
+		a.Low == b.Low && a.High == b.High && a.Open == b.Open && a.Close == b.Close && a.Volume == b.Volume
+}
+
+func TestExtractMultipleRanges(t *testing.T) {
+	srv := gdaxtest.NewServer(nil)
+	defer srv.Close()
+
+	// 1000 minutes is split into 5 requests of 200 candlesticks
+	end := testStart.Add(1000 * time.Minute)
+	cdls, errs, err := collect(t, context.Background(), newExtractor(srv, testStart, end, nil), nil)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v %v", err, errs)
+	}
+	if srv.Requests() != 5 {
+		t.Errorf("expected 5 requests, got %d", srv.Requests())
+	}
+	assertCandles(t, cdls, srv.Candles(product, testStart, end, 60))
+}
+
+func TestRetryRateLimited(t *testing.T) {
+	srv := gdaxtest.NewServer(&gdaxtest.Config{RateLimitEvery: 2})
+	defer srv.Close()
+
+	end := testStart.Add(1000 * time.Minute)
+	cdls, errs, err := collect(t, context.Background(), newExtractor(srv, testStart, end, nil), nil)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v %v", err, errs)
+	}
+	// every other request is rate limited and retried
+	if srv.Requests() != 9 {
+		t.Errorf("expected 9 requests, got %d", srv.Requests())
+	}
+	assertCandles(t, cdls, srv.Candles(product, testStart, end, 60))
+}
+
+func TestRetryServerError(t *testing.T) {
+	srv := gdaxtest.NewServer(&gdaxtest.Config{ErrorEvery: 3})
+	defer srv.Close()
+
+	end := testStart.Add(1000 * time.Minute)
+	cdls, errs, err := collect(t, context.Background(), newExtractor(srv, testStart, end, nil), nil)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v %v", err, errs)
+	}
+	assertCandles(t, cdls, srv.Candles(product, testStart, end, 60))
+}
+
+func TestRetryExhausted(t *testing.T) {
+	srv := gdaxtest.NewServer(&gdaxtest.Config{ErrorEvery: 1})
+	defer srv.Close()
+
+	end := testStart.Add(100 * time.Minute)
+	cdls, errs, err := collect(t, context.Background(), newExtractor(srv, testStart, end, nil), nil)
+	if err != nil {
+		t.Fatalf("unexpected collection error: %s", err)
+	}
+	if len(cdls) != 0 {
+		t.Errorf("expected no candlesticks, got %d", len(cdls))
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected a single error, got %v", errs)
+	}
+	rErr, ok := errs[0].(*extractor.RangeError)
+	if !ok {
+		t.Fatalf("expected a *RangeError, got %T", errs[0])
+	}
+	if rErr.StatusCode != 500 || rErr.Attempts != 3 {
+		t.Errorf("expected status 500 after 3 attempts, got status %d after %d", rErr.StatusCode, rErr.Attempts)
+	}
+}
+
+func TestOrderDropsBoundaryDuplicates(t *testing.T) {
+	srv := gdaxtest.NewServer(nil)
+	defer srv.Close()
+
+	end := testStart.Add(450 * time.Minute)
+	want := srv.Candles(product, testStart, end, 60)
+
+	t.Run("ascending", func(t *testing.T) {
+		cdls, _, err := collect(t, context.Background(), newExtractor(srv, testStart, end, nil), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertCandles(t, cdls, want)
+	})
+
+	t.Run("descending", func(t *testing.T) {
+		ext := newExtractor(srv, testStart, end, func(c *extractor.ExtractorConfig) {
+			c.Order = extractor.OrderDescending
+		})
+		cdls, _, err := collect(t, context.Background(), ext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc := make([]extractor.Candlestick, len(want))
+		for i := range want {
+			desc[len(want)-1-i] = want[i]
+		}
+		assertCandles(t, cdls, desc)
+	})
+
+	t.Run("as received", func(t *testing.T) {
+		ext := newExtractor(srv, testStart, end, func(c *extractor.ExtractorConfig) {
+			c.Order = extractor.OrderAsReceived
+		})
+		cdls, _, err := collect(t, context.Background(), ext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the candlesticks on the 2 boundaries between the 3 ranges are sent twice
+		if len(cdls) != len(want)+2 {
+			t.Errorf("expected %d candlesticks, got %d", len(want)+2, len(cdls))
+		}
+	})
+}
+
+func TestGapDetection(t *testing.T) {
+	gapped := map[int64]bool{
+		testStart.Add(10 * time.Minute).Unix():  true,
+		testStart.Add(11 * time.Minute).Unix():  true,
+		testStart.Add(250 * time.Minute).Unix(): true,
+	}
+	srv := gdaxtest.NewServer(&gdaxtest.Config{
+		Missing: func(product string, t time.Time) bool {
+			return gapped[t.Unix()]
+		},
+	})
+	defer srv.Close()
+
+	end := testStart.Add(300 * time.Minute)
+	ext := newExtractor(srv, testStart, end, func(c *extractor.ExtractorConfig) {
+		c.DetectGaps = true
+	})
+	var gaps []*extractor.GapEvent
+	cdls, _, err := collect(t, context.Background(), ext, func(c *extractor.CollectorConfig) {
+		c.GapHandler = func(g *extractor.GapEvent) {
+			gaps = append(gaps, g)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCandles(t, cdls, srv.Candles(product, testStart, end, 60))
+
+	var missing []int64
+	for _, g := range gaps {
+		if g.Refetched {
+			t.Errorf("expected gap not to be refetched: %s", g)
+		}
+		for _, m := range g.Missing {
+			missing = append(missing, m.Unix())
+		}
+	}
+	if len(missing) != len(gapped) {
+		t.Fatalf("expected %d missing candlesticks, got %v", len(gapped), missing)
+	}
+	for _, m := range missing {
+		if !gapped[m] {
+			t.Errorf("unexpected missing candlestick at %d", m)
+		}
+	}
+}
+
+func TestGapRefetch(t *testing.T) {
+	// candlesticks are missing from the first response which covers them, and returned when requested again
+	var mutex sync.Mutex
+	served := map[int64]bool{}
+	done := false
+	gapped := map[int64]bool{
+		testStart.Add(10 * time.Minute).Unix(): true,
+		testStart.Add(11 * time.Minute).Unix(): true,
+	}
+	srv := gdaxtest.NewServer(&gdaxtest.Config{
+		Missing: func(product string, t time.Time) bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if done || !gapped[t.Unix()] || served[t.Unix()] {
+				return false
+			}
+			served[t.Unix()] = true
+			return true
+		},
+	})
+	defer srv.Close()
+
+	end := testStart.Add(100 * time.Minute)
+	ext := newExtractor(srv, testStart, end, func(c *extractor.ExtractorConfig) {
+		c.RefetchGaps = true
+	})
+	var gaps []*extractor.GapEvent
+	cdls, _, err := collect(t, context.Background(), ext, func(c *extractor.CollectorConfig) {
+		c.GapHandler = func(g *extractor.GapEvent) {
+			gaps = append(gaps, g)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 0 {
+		t.Errorf("expected refetched gaps not to be reported, got %v", gaps)
+	}
+	// the initial request and a single refetch of the contiguous run
+	if srv.Requests() != 2 {
+		t.Errorf("expected 2 requests, got %d", srv.Requests())
+	}
+	mutex.Lock()
+	done = true
+	mutex.Unlock()
+	assertCandles(t, cdls, srv.Candles(product, testStart, end, 60))
+}
+
+func TestCancel(t *testing.T) {
+	srv := gdaxtest.NewServer(&gdaxtest.Config{Latency: 50 * time.Millisecond})
+	defer srv.Close()
+
+	// a year of minutes would take thousands of requests to extract
+	end := testStart.Add(365 * 24 * time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	began := time.Now()
+	cdls, errs, err := collect(t, ctx, newExtractor(srv, testStart, end, nil), nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+	if elapsed := time.Since(began); elapsed > 2*time.Second {
+		t.Errorf("expected collection to end promptly once cancelled, took %s", elapsed)
+	}
+	if len(errs) != 0 {
+		t.Errorf("expected cancellation not to be reported as an error, got %v", errs)
+	}
+	if len(cdls) == 0 {
+		t.Fatal("expected candlesticks to be collected before the cancellation")
+	}
+	// whatever was collected is the start of the extraction, in order
+	assertCandles(t, cdls, srv.Candles(product, testStart, testStart.Add(time.Duration(len(cdls)-1)*time.Minute), 60))
+}
@@ -0,0 +1,131 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// GapEvent reports candlesticks which were expected from a requested range, but not returned by the
+// exchange. gaps are commonly periods without trades, but may also be caused by exchange outages
+type GapEvent struct {
+	Product     string
+	Granularity int
+	// Start and End are the bounds of the requested range
+	Start time.Time
+	End   time.Time
+	// Missing is the start time of each expected candlestick which was not returned
+	Missing []time.Time
+	// Refetched is true if the missing candlesticks were requested again before the gap was reported
+	Refetched bool
+}
+
+// String describes the gap for logging
+func (g *GapEvent) String() string {
+	return fmt.Sprintf("[%s:%d] (%s - %s) missing %d candle(s)", g.Product, g.Granularity, g.Start.String(), g.End.String(), len(g.Missing))
+}
+
+// Gaps returns the gap channel
+func (m *Extractor) Gaps() chan *GapEvent {
+	return m.GapChan
+}
+
+// checkGaps compares the candlesticks to those expected for the range, optionally requesting the missing
+// sub-ranges again. the candlesticks, including any refetched, are returned with a gap event if any are still missing
+func (m *Extractor) checkGaps(ctx context.Context, product string, start time.Time, end time.Time, granularity int, cdls []Candlestick) ([]Candlestick, *GapEvent) {
+	missing := missingTimestamps(cdls, start, end, granularity)
+	if len(missing) == 0 {
+		return cdls, nil
+	}
+
+	gap := &GapEvent{
+		Product:     product,
+		Granularity: granularity,
+		Start:       start,
+		End:         end,
+		Missing:     missing,
+	}
+	if !m.Config.RefetchGaps {
+		return cdls, gap
+	}
+
+	// request each contiguous run of missing candlesticks, keeping only those which weren't already returned
+	gap.Refetched = true
+	step := time.Duration(granularity) * time.Second
+	for _, run := range contiguousRuns(missing, step) {
+		refetched, err := m.getCandleRangeWithRetry(ctx, product, run[0], run[1], granularity)
+		if err != nil {
+			// the gap is still reported, so the failure only needs to be logged
+			if m.Config.Logger != nil {
+				m.Logger.Printf("\n=> REFETCH: [%s:%d] failed: %s\n", product, granularity, err.Error())
+			}
+			continue
+		}
+		for _, cdl := range refetched {
+			if isMissing(missing, cdl.Timestamp) && !hasTimestamp(cdls, cdl.Timestamp) {
+				cdls = append(cdls, cdl)
+			}
+		}
+	}
+
+	gap.Missing = missingTimestamps(cdls, start, end, granularity)
+	if len(gap.Missing) == 0 {
+		return cdls, nil
+	}
+	return cdls, gap
+}
+
+// missingTimestamps returns the expected candlestick times in [start, end) which are not in the set.
+// candlesticks are expected at multiples of the granularity, excluding any bucket which has not yet closed
+func missingTimestamps(cdls []Candlestick, start time.Time, end time.Time, granularity int) []time.Time {
+	var missing []time.Time
+	if granularity <= 0 {
+		return missing
+	}
+
+	g := int64(granularity)
+	found := make(map[int64]bool, len(cdls))
+	for _, cdl := range cdls {
+		found[cdl.Timestamp] = true
+	}
+
+	closed := time.Now().Unix() - g
+	first := (start.Unix() + g - 1) / g * g
+	for t := first; t < end.Unix() && t <= closed; t += g {
+		if !found[t] {
+			missing = append(missing, time.Unix(t, 0).UTC())
+		}
+	}
+	return missing
+}
+
+// contiguousRuns groups sorted times into [first, last] runs where each time follows the last by the step
+func contiguousRuns(ts []time.Time, step time.Duration) [][]time.Time {
+	var runs [][]time.Time
+	for _, t := range ts {
+		if n := len(runs); n > 0 && t.Sub(runs[n-1][1]) == step {
+			runs[n-1][1] = t
+			continue
+		}
+		runs = append(runs, []time.Time{t, t})
+	}
+	return runs
+}
+
+func isMissing(missing []time.Time, ts int64) bool {
+	for _, t := range missing {
+		if t.Unix() == ts {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTimestamp(cdls []Candlestick, ts int64) bool {
+	for _, cdl := range cdls {
+		if cdl.Timestamp == ts {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package extractor
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+)
+
+// SupportedGranularities is the set of granularities, in seconds, served by the GDAX API
+var SupportedGranularities = []int{60, 300, 900, 3600, 21600, 86400}
+
+// GDAXSource implements Source for the GDAX API
+type GDAXSource struct {
+	Client     *exchange.Client
+	HTTPClient *http.Client
+}
+
+// NewGDAXSource builds a GDAX source. a client with a 30 second timeout is used if the http client is nil.
+// the credentials may be empty, in which case requests are made without authentication
+func NewGDAXSource(secret string, key string, passphrase string, httpClient *http.Client) *GDAXSource {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &GDAXSource{
+		Client:     exchange.NewClient(secret, key, passphrase),
+		HTTPClient: httpClient,
+	}
+}
+
+// Name returns the source name of GDAX candlesticks
+func (s *GDAXSource) Name() string {
+	return SourceGDAX
+}
+
+// MaxCandles returns the number of candlesticks requested at once, within the bounds of the GDAX API
+func (s *GDAXSource) MaxCandles() int {
+	return 200
+}
+
+// Granularities returns the granularities served by the GDAX API
+func (s *GDAXSource) Granularities() []int {
+	return SupportedGranularities
+}
+
+// GetCandles requests the historic rates for the product, range, and granularity
+func (s *GDAXSource) GetCandles(ctx context.Context, product string, start time.Time, end time.Time, granularity int) ([]Candlestick, error) {
+	var rts []exchange.HistoricRate
+	res, err := s.getJSON(ctx, candlesPath(product, start, end, granularity), &rts)
+	if err != nil {
+		if res != nil {
+			return []Candlestick{}, &StatusError{
+				StatusCode: res.StatusCode,
+				RetryAfter: parseRetryAfter(res),
+				Err:        err,
+			}
+		}
+		return []Candlestick{}, err
+	}
+
+	return CandlesFromRates(product, granularity, rts), nil
+}
+
+// Authenticated returns true if the source has credentials to sign requests with
+func (s *GDAXSource) Authenticated() bool {
+	return s.Client.Key != "" || s.Client.Secret != "" || s.Client.Passphrase != ""
+}
+
+// checkCredentials returns an error if credentials were only partially provided, or the secret is not
+// valid base64. no credentials at all is valid, as historic rates are public
+func (s *GDAXSource) checkCredentials() error {
+	if !s.Authenticated() {
+		return nil
+	}
+	if s.Client.Key == "" || s.Client.Secret == "" || s.Client.Passphrase == "" {
+		return errors.New("Incomplete GDAX credentials, a key, secret and passphrase are all required for authentication")
+	}
+	if _, err := base64.StdEncoding.DecodeString(s.Client.Secret); err != nil {
+		return fmt.Errorf("Invalid GDAX secret: %s", err.Error())
+	}
+	return nil
+}
+
+// getJSON makes a GET request against the GDAX API and decodes the response into result. the request is signed
+// if the source has credentials.
+// the response is returned whenever one was received, so callers can inspect the status and headers
+func (s *GDAXSource) getJSON(ctx context.Context, path string, result interface{}) (*http.Response, error) {
+	req, err := http.NewRequest("GET", s.Client.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	if s.Authenticated() {
+		if err = s.sign(req, path); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return res, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		bts, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return res, err
+		}
+		exErr := exchange.Error{}
+		if json.Unmarshal(bts, &exErr) != nil || exErr.Message == "" {
+			exErr.Message = fmt.Sprintf("%s %s", res.Status, string(bts))
+		}
+		return res, exErr
+	}
+
+	return res, json.NewDecoder(res.Body).Decode(result)
+}
+
+// sign adds the GDAX authentication headers to the request
+func (s *GDAXSource) sign(req *http.Request, path string) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	key, err := base64.StdEncoding.DecodeString(s.Client.Secret)
+	if err != nil {
+		return fmt.Errorf("Invalid GDAX secret: %s", err.Error())
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp + req.Method + path))
+
+	req.Header.Set("CB-ACCESS-KEY", s.Client.Key)
+	req.Header.Set("CB-ACCESS-PASSPHRASE", s.Client.Passphrase)
+	req.Header.Set("CB-ACCESS-TIMESTAMP", timestamp)
+	req.Header.Set("CB-ACCESS-SIGN", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// candlesPath builds the request path for the historic rates endpoint
+func candlesPath(product string, start time.Time, end time.Time, granularity int) string {
+	values := url.Values{}
+	values.Add("start", start.UTC().Format(time.RFC3339))
+	values.Add("end", end.UTC().Format(time.RFC3339))
+	values.Add("granularity", strconv.Itoa(granularity))
+	return fmt.Sprintf("/products/%s/candles?%s", product, values.Encode())
+}
@@ -0,0 +1,113 @@
+package extractor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// weekOffset aligns weekly buckets to Monday, as the unix epoch began on a Thursday
+const weekOffset = 4 * 24 * 60 * 60
+
+// GranularityError is returned when an extraction requests a granularity the exchange does not serve
+type GranularityError struct {
+	Granularity int
+	Supported   []int
+	// Aggregatable is true if the granularity can be fulfilled by enabling aggregation
+	Aggregatable bool
+}
+
+// Error implements the error interface
+func (e *GranularityError) Error() string {
+	msg := fmt.Sprintf("Unsupported granularity [%d]: expected one of %v", e.Granularity, e.Supported)
+	if e.Aggregatable {
+		msg += ", or enable aggregation"
+	}
+	return msg
+}
+
+// Validate checks the extraction can be fulfilled by an exchange serving the granularities, returning a
+// *GranularityError if the granularity is not supported
+func (c *ExtractionConfig) Validate(granularities []int) error {
+	if len(c.products()) == 0 {
+		return errors.New("No product set for the extraction")
+	}
+	if !c.End.After(c.Start) {
+		return fmt.Errorf("Extraction end [%s] must be after start [%s]", c.End.String(), c.Start.String())
+	}
+	_, err := c.fetchGranularity(granularities)
+	return err
+}
+
+// fetchGranularity returns the granularity to request from the exchange to fulfill the extraction. unsupported
+// granularities are fetched at the largest supported granularity which divides them, if aggregation is enabled
+func (c *ExtractionConfig) fetchGranularity(granularities []int) (int, error) {
+	for _, g := range granularities {
+		if g == c.Granularity {
+			return g, nil
+		}
+	}
+
+	finer := 0
+	if c.Granularity > 0 {
+		for _, g := range granularities {
+			// a bucket must fit within a single request to be aggregated
+			if g > finer && g < c.Granularity && c.Granularity%g == 0 && c.Granularity <= g*200 {
+				finer = g
+			}
+		}
+	}
+	if finer == 0 || !c.Aggregate {
+		return 0, &GranularityError{
+			Granularity:  c.Granularity,
+			Supported:    granularities,
+			Aggregatable: finer != 0,
+		}
+	}
+	return finer, nil
+}
+
+// alignTime returns the start of the bucket of the given granularity which contains the time. buckets
+// are aligned to the unix epoch, excepting weekly buckets which begin on Monday
+func alignTime(t time.Time, granularity int) time.Time {
+	g := int64(granularity)
+	offset := int64(0)
+	if g%(7*24*60*60) == 0 {
+		offset = weekOffset
+	}
+	ts := t.Unix() - offset
+	aligned := ts - ts%g
+	if ts < 0 && ts%g != 0 {
+		aligned -= g
+	}
+	return time.Unix(aligned+offset, 0).UTC()
+}
+
+// aggregateCandles combines the candlesticks into buckets of the coarser granularity. candlesticks
+// outside of [start, end) are dropped, as they belong to buckets of the neighbouring ranges
+func aggregateCandles(cdls []Candlestick, start time.Time, end time.Time, granularity int) []Candlestick {
+	sorted := make([]Candlestick, 0, len(cdls))
+	for _, c := range cdls {
+		if c.Timestamp >= start.Unix() && c.Timestamp < end.Unix() {
+			sorted = append(sorted, c)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Timestamp < sorted[j].Timestamp })
+
+	var aggs []Candlestick
+	for i := range sorted {
+		bucket := alignTime(time.Unix(sorted[i].Timestamp, 0), granularity)
+		if n := len(aggs); n > 0 && aggs[n-1].Timestamp == bucket.Unix() {
+			aggs[n-1].Merge(&sorted[i])
+			continue
+		}
+
+		agg := sorted[i]
+		agg.Granularity = granularity
+		agg.Datetime = bucket.String()
+		agg.Timestamp = bucket.Unix()
+		aggs = append(aggs, agg)
+	}
+	return aggs
+}
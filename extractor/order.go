@@ -0,0 +1,50 @@
+package extractor
+
+import "sort"
+
+// Order is the order candlesticks are sent on the candlestick channel
+type Order int
+
+const (
+	// OrderAscending sends candlesticks oldest first, without duplicates
+	OrderAscending Order = iota
+	// OrderDescending sends candlesticks newest first, without duplicates. ranges are requested from the end
+	// of the extraction, so it can't be checkpointed
+	OrderDescending
+	// OrderAsReceived sends candlesticks in the order GDAX returns them, newest first within each ascending
+	// range. the candlestick on the boundary of two ranges is sent twice
+	OrderAsReceived
+)
+
+// orderer sorts the candlesticks of each range for a single product, dropping any already sent
+type orderer struct {
+	order Order
+	last  int64
+	sent  bool
+}
+
+// apply sorts the candlesticks according to the order, removing those at or beyond the last sent
+func (o *orderer) apply(cdls []Candlestick) []Candlestick {
+	if o.order == OrderAsReceived || len(cdls) == 0 {
+		return cdls
+	}
+
+	asc := o.order == OrderAscending
+	sort.SliceStable(cdls, func(i, j int) bool {
+		if asc {
+			return cdls[i].Timestamp < cdls[j].Timestamp
+		}
+		return cdls[i].Timestamp > cdls[j].Timestamp
+	})
+
+	out := cdls[:0]
+	for _, c := range cdls {
+		if o.sent && ((asc && c.Timestamp <= o.last) || (!asc && c.Timestamp >= o.last)) {
+			continue
+		}
+		out = append(out, c)
+		o.last = c.Timestamp
+		o.sent = true
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package extractor
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRequestsPerSecond is the sustained request rate allowed by the public GDAX API
+	DefaultRequestsPerSecond = 3
+	// DefaultBurst is the number of requests which may be made back to back before the rate applies
+	DefaultBurst = 1
+)
+
+// defaultLimiter is shared by every extractor built without a rate limiter, so that
+// running several extractors in one process stays within the API limit
+var defaultLimiter = NewTokenBucket(DefaultRequestsPerSecond, DefaultBurst)
+
+// RateLimiter gates requests made to the exchange. implementations must be safe for
+// concurrent use, allowing a single limiter to be shared by multiple extractors
+type RateLimiter interface {
+	// Wait blocks until a request may be made, returning the context error if it is cancelled first
+	Wait(ctx context.Context) error
+	// Backoff blocks all requests for the duration, e.g. when the exchange responds with Retry-After
+	Backoff(d time.Duration)
+}
+
+// TokenBucket implements RateLimiter, allowing a sustained rate of requests per second with an
+// initial burst
+type TokenBucket struct {
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	blocked time.Time
+	mutex   *sync.Mutex
+}
+
+// NewTokenBucket builds a full token bucket refilled at rps tokens per second, holding at most burst tokens
+func NewTokenBucket(rps float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rps,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		mutex:  &sync.Mutex{},
+	}
+}
+
+// Wait blocks until a token is available, then consumes it
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	for {
+		wait := b.take()
+		if wait <= 0 {
+			return nil
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Backoff empties the bucket and prevents tokens being taken until the duration has passed
+func (b *TokenBucket) Backoff(d time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	until := time.Now().Add(d)
+	if until.After(b.blocked) {
+		b.blocked = until
+	}
+	b.tokens = 0
+	b.last = until
+}
+
+// take consumes a token if one is available, otherwise it returns the time until one will be
+func (b *TokenBucket) take() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if now.Before(b.blocked) {
+		return b.blocked.Sub(now)
+	}
+
+	// refill according to the time passed since the last take
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	if b.rate <= 0 {
+		return time.Second
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// parseRetryAfter reads the Retry-After header as either delay seconds or an HTTP date
+func parseRetryAfter(res *http.Response) time.Duration {
+	h := res.Header.Get("Retry-After")
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
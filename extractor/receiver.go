@@ -5,4 +5,20 @@ package extractor
 type Receiver interface {
 	Collect(*Candlestick) error
 	Close()
-}
\ No newline at end of file
+}
+
+// BatchReceiver may be implemented by a Receiver to collect candlesticks in batches, e.g. to reduce the
+// number of writes or requests. the collector passes batches bounded by the BatchSize and BatchLatency of
+// the receiver's delivery config in place of calling Collect
+type BatchReceiver interface {
+	Receiver
+	CollectBatch([]*Candlestick) error
+}
+
+// Finisher may be implemented by a Receiver whose output is only complete once it's finished, e.g. a file
+// with a footer. the collector calls Finish once the receiver has been passed every candlestick, and the
+// collection fails if it returns an error. Close is still called, and must not fail once finished
+type Finisher interface {
+	Receiver
+	Finish() error
+}
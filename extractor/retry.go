@@ -0,0 +1,89 @@
+package extractor
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryConfig describes how a failed candle range request is retried before
+// the failure is reported on the error channel
+type RetryConfig struct {
+	// MaxAttempts is the total number of requests made for a single range, including the first
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry. each subsequent retry doubles it
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between any two attempts. 0 leaves the wait uncapped
+	MaxDelay time.Duration
+	// Jitter randomizes each wait by up to the given fraction (0-1) of the delay
+	Jitter float64
+	// RetryableStatuses is the list of HTTP status codes which are considered transient.
+	// requests which fail without a response (timeouts, resets) are always retried
+	RetryableStatuses []int
+}
+
+// DefaultRetryConfig returns the retry policy used when none is provided
+func DefaultRetryConfig() *RetryConfig {
+	return &RetryConfig{
+		MaxAttempts:       5,
+		BaseDelay:         time.Second,
+		MaxDelay:          30 * time.Second,
+		Jitter:            0.2,
+		RetryableStatuses: []int{429, 500, 502, 503, 504},
+	}
+}
+
+// RangeError is reported when a candle range could not be retrieved from the exchange
+type RangeError struct {
+	Source      string
+	Product     string
+	Start       time.Time
+	End         time.Time
+	Granularity int
+	// StatusCode is the HTTP status of the last response, or 0 if no response was received
+	StatusCode int
+	// RetryAfter is the wait requested by the exchange in the Retry-After header, if any
+	RetryAfter time.Duration
+	// Attempts is the number of requests made for the range
+	Attempts int
+	Err      error
+}
+
+// Error implements the error interface
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("Request Error: [%s %s:%d] (%s - %s) status %d after %d attempt(s): %s",
+		e.Source, e.Product, e.Granularity, e.Start.String(), e.End.String(), e.StatusCode, e.Attempts, e.Err.Error())
+}
+
+// retryable reports whether the failed request should be attempted again
+func (r *RetryConfig) retryable(err *RangeError) bool {
+	if err.StatusCode == 0 {
+		return true
+	}
+	for _, s := range r.RetryableStatuses {
+		if s == err.StatusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the given retry, starting at 1
+func (r *RetryConfig) backoff(retry int) time.Duration {
+	d := r.BaseDelay
+	// doubling stops at the cap, or before the duration would overflow when uncapped
+	for i := 1; i < retry && (r.MaxDelay <= 0 || d < r.MaxDelay) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if r.MaxDelay > 0 && d > r.MaxDelay {
+		d = r.MaxDelay
+	}
+	if r.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * r.Jitter * float64(d))
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
@@ -0,0 +1,32 @@
+package extractor
+
+import (
+	"context"
+	"time"
+)
+
+// Source provides candlesticks from an exchange, allowing the extractor to drive any exchange API
+type Source interface {
+	// Name identifies the exchange. it is set as the source of candlesticks which don't provide one
+	Name() string
+	// GetCandles returns the candlesticks for the product, range, and granularity. a *StatusError
+	// should be returned for failed HTTP requests, allowing the retry policy to classify the failure
+	GetCandles(ctx context.Context, product string, start time.Time, end time.Time, granularity int) ([]Candlestick, error)
+	// MaxCandles is the number of candlesticks requested for each range
+	MaxCandles() int
+	// Granularities is the set of granularities, in seconds, served by the exchange
+	Granularities() []int
+}
+
+// StatusError is returned by a Source when a request receives an unsuccessful response
+type StatusError struct {
+	StatusCode int
+	// RetryAfter is the wait requested by the exchange in the Retry-After header, if any
+	RetryAfter time.Duration
+	Err        error
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
@@ -0,0 +1,75 @@
+package extractor
+
+// Transform modifies candlesticks between the extractor and receivers. each candlestick passed to Apply
+// produces zero or more candlesticks, so a transform may filter, enrich or split them. Flush is called
+// once the extraction ends, for transforms which hold candlesticks back. transforms must not modify the
+// candlesticks they are passed, as they are shared between receivers, and an instance must not be used
+// in more than one chain
+type Transform interface {
+	Apply(*Candlestick) ([]*Candlestick, error)
+	Flush() ([]*Candlestick, error)
+}
+
+// Chain applies each transform in turn to the output of the previous. it implements Transform
+type Chain []Transform
+
+// Apply passes the candlestick through every transform of the chain
+func (ch Chain) Apply(cdl *Candlestick) ([]*Candlestick, error) {
+	return ch.applyFrom(0, []*Candlestick{cdl})
+}
+
+// Flush flushes every transform of the chain in order, passing the flushed candlesticks through the
+// transforms after it
+func (ch Chain) Flush() ([]*Candlestick, error) {
+	var out []*Candlestick
+	for i, t := range ch {
+		cdls, err := t.Flush()
+		if err != nil {
+			return out, err
+		}
+		cdls, err = ch.applyFrom(i+1, cdls)
+		out = append(out, cdls...)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+func (ch Chain) applyFrom(start int, cdls []*Candlestick) ([]*Candlestick, error) {
+	for _, t := range ch[start:] {
+		var next []*Candlestick
+		for _, cdl := range cdls {
+			out, err := t.Apply(cdl)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, out...)
+		}
+		cdls = next
+	}
+	return cdls, nil
+}
+
+// Holder may be implemented by a Transform to report whether it holds candlesticks back, returning them
+// from a later Apply or from Flush. the output of a transform which does can't be matched to the
+// candlesticks it came from, so they're only acknowledged once it's flushed and everything it returned
+// was delivered. transforms which don't implement Holder are assumed to hold candlesticks back
+type Holder interface {
+	HoldsBack() bool
+}
+
+// HoldsBack reports whether any transform of the chain holds candlesticks back
+func (ch Chain) HoldsBack() bool {
+	for _, t := range ch {
+		if holdsBack(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func holdsBack(t Transform) bool {
+	h, ok := t.(Holder)
+	return !ok || h.HoldsBack()
+}
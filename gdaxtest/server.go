@@ -0,0 +1,196 @@
+package gdaxtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+)
+
+// DefaultMaxCandles is the number of candlesticks GDAX will return for a single request
+const DefaultMaxCandles = 300
+
+// Config provides values for the behaviour of the mock server
+type Config struct {
+	// Latency delays every response
+	Latency time.Duration
+	// RateLimitEvery responds to every Nth request with 429 Too Many Requests, if set
+	RateLimitEvery int
+	// RetryAfter is sent in the Retry-After header of rate limited responses, if set
+	RetryAfter time.Duration
+	// ErrorEvery responds to every Nth request with 500 Internal Server Error, if set
+	ErrorEvery int
+	// MaxCandles is the most candlesticks a request may cover. defaults to DefaultMaxCandles
+	MaxCandles int
+	// Granularities served by the server. defaults to extractor.SupportedGranularities
+	Granularities []int
+	// Missing omits the candlestick for the product and time from responses if it returns true, to simulate
+	// periods without trades
+	Missing func(product string, t time.Time) bool
+}
+
+// Server emulates the GDAX historic rates endpoint, `/products/{id}/candles`, with deterministic
+// synthetic data. point an extractor at it with ExtractorConfig.BaseURL
+type Server struct {
+	*httptest.Server
+	Config   *Config
+	requests int64
+}
+
+// NewServer starts a mock GDAX server. it must be closed when no longer needed
+func NewServer(config *Config) *Server {
+	if config == nil {
+		config = &Config{}
+	}
+	if config.MaxCandles == 0 {
+		config.MaxCandles = DefaultMaxCandles
+	}
+	if len(config.Granularities) == 0 {
+		config.Granularities = extractor.SupportedGranularities
+	}
+
+	s := &Server{Config: config}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Requests returns the number of requests the server has received
+func (s *Server) Requests() int {
+	return int(atomic.LoadInt64(&s.requests))
+}
+
+// Candles returns the candlesticks the server responds with for the range, oldest first. useful for
+// asserting on the output of an extraction
+func (s *Server) Candles(product string, start time.Time, end time.Time, granularity int) []extractor.Candlestick {
+	var cdls []extractor.Candlestick
+	if granularity <= 0 {
+		return cdls
+	}
+
+	g := int64(granularity)
+	now := time.Now().Unix()
+	for ts := (start.Unix() + g - 1) / g * g; ts <= end.Unix() && ts <= now; ts += g {
+		t := time.Unix(ts, 0).UTC()
+		if s.Config.Missing != nil && s.Config.Missing(product, t) {
+			continue
+		}
+		cdls = append(cdls, Candle(product, granularity, t))
+	}
+	return cdls
+}
+
+// Candle returns the synthetic candlestick for the product, granularity and time. the values are
+// derived from the inputs alone, so every run produces the same data
+func Candle(product string, granularity int, t time.Time) extractor.Candlestick {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s:%d:%d", product, granularity, t.Unix())
+	seed := h.Sum64()
+
+	base := 1000 + 500*math.Sin(float64(t.Unix())/86400/30)
+	open := round(base + float64(seed%1000)/100)
+	cls := round(base + float64((seed>>10)%1000)/100)
+	high := round(math.Max(open, cls) + float64((seed>>20)%500)/100)
+	low := round(math.Min(open, cls) - float64((seed>>30)%500)/100)
+	vol := round(float64((seed>>40)%100000) / 100)
+
+	return extractor.Candlestick{
+		Product:     product,
+		Source:      extractor.SourceGDAX,
+		Datetime:    t.UTC().String(),
+		Granularity: granularity,
+		Low:         low,
+		High:        high,
+		Open:        open,
+		Close:       cls,
+		Volume:      vol,
+		Timestamp:   t.Unix(),
+	}
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	n := int(atomic.AddInt64(&s.requests, 1))
+	if s.Config.Latency > 0 {
+		select {
+		case <-time.After(s.Config.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if s.Config.RateLimitEvery > 0 && n%s.Config.RateLimitEvery == 0 {
+		if s.Config.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.Config.RetryAfter.Seconds()))))
+		}
+		writeError(w, http.StatusTooManyRequests, "Public rate limit exceeded")
+		return
+	}
+	if s.Config.ErrorEvery > 0 && n%s.Config.ErrorEvery == 0 {
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	// only /products/{id}/candles is served
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method != "GET" || len(parts) != 3 || parts[0] != "products" || parts[2] != "candles" {
+		writeError(w, http.StatusNotFound, "NotFound")
+		return
+	}
+	product := parts[1]
+
+	q := r.URL.Query()
+	granularity, err := strconv.Atoi(q.Get("granularity"))
+	if err != nil || !s.supported(granularity) {
+		writeError(w, http.StatusBadRequest, "Unsupported granularity")
+		return
+	}
+	start, err := time.Parse(time.RFC3339, q.Get("start"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid start")
+		return
+	}
+	end, err := time.Parse(time.RFC3339, q.Get("end"))
+	if err != nil || end.Before(start) {
+		writeError(w, http.StatusBadRequest, "Invalid end")
+		return
+	}
+	if int(end.Sub(start)/time.Second)/granularity > s.Config.MaxCandles {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("granularity too small for the requested time range. Count of aggregations requested exceeds %d", s.Config.MaxCandles))
+		return
+	}
+
+	// GDAX responds newest first, as [time, low, high, open, close, volume]
+	cdls := s.Candles(product, start, end, granularity)
+	rates := make([][]interface{}, len(cdls))
+	for i, c := range cdls {
+		rates[len(cdls)-1-i] = []interface{}{c.Timestamp, c.Low, c.High, c.Open, c.Close, c.Volume}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rates)
+}
+
+func (s *Server) supported(granularity int) bool {
+	for _, g := range s.Config.Granularities {
+		if g == granularity {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": msg})
+}
+
+func round(f float64) float64 {
+	return math.Floor(f*100+0.5) / 100
+}
@@ -0,0 +1,73 @@
+package indicators
+
+import (
+	"fmt"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+)
+
+// Enricher implements extractor.Transform, setting the values of its indicators in the Fields of each
+// candlestick. state is kept separately for each source, product and granularity, so interleaved products
+// are computed independently. candlesticks must arrive in chronological order for each series, those at
+// or before the latest seen are passed on without values and don't change the state
+type Enricher struct {
+	Indicators []Indicator
+	series     map[string]*series
+}
+
+// series is the state of the indicators for a single source, product and granularity
+type series struct {
+	indicators []Indicator
+	latest     int64
+}
+
+// New builds an enricher computing the indicators. the instances passed are used as templates, and are
+// cloned for each series
+func New(inds ...Indicator) *Enricher {
+	return &Enricher{Indicators: inds, series: map[string]*series{}}
+}
+
+// Fields are the names of the values set by every indicator, in order
+func (e *Enricher) Fields() []string {
+	var fields []string
+	for _, ind := range e.Indicators {
+		fields = append(fields, ind.Fields()...)
+	}
+	return fields
+}
+
+// Apply passes on a copy of the candlestick with the indicator values added to its fields
+func (e *Enricher) Apply(c *extractor.Candlestick) ([]*extractor.Candlestick, error) {
+	key := fmt.Sprintf("%s:%s:%d", c.Source, c.Product, c.Granularity)
+	s := e.series[key]
+	if s == nil {
+		s = &series{}
+		for _, ind := range e.Indicators {
+			s.indicators = append(s.indicators, ind.Clone())
+		}
+		e.series[key] = s
+	} else if c.Timestamp <= s.latest {
+		return []*extractor.Candlestick{c}, nil
+	}
+	s.latest = c.Timestamp
+
+	out := *c
+	out.Fields = make(map[string]float64, len(c.Fields))
+	for k, v := range c.Fields {
+		out.Fields[k] = v
+	}
+	for _, ind := range s.indicators {
+		ind.Update(c, out.Fields)
+	}
+	return []*extractor.Candlestick{&out}, nil
+}
+
+// Flush acts as a no-op, as nothing is held back
+func (e *Enricher) Flush() ([]*extractor.Candlestick, error) {
+	return nil, nil
+}
+
+// HoldsBack implements extractor.Holder, as nothing is held back
+func (e *Enricher) HoldsBack() bool {
+	return false
+}
@@ -0,0 +1,451 @@
+package indicators
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+)
+
+// Indicator computes values over a stream of candlesticks of a single product and granularity. the
+// candlesticks are passed to Update in chronological order
+type Indicator interface {
+	// Fields are the names of the values the indicator sets
+	Fields() []string
+	// Update adds the candlestick to the indicator's state, setting its values once enough candlesticks
+	// have been seen
+	Update(c *extractor.Candlestick, values map[string]float64)
+	// Clone returns an indicator with the same parameters and no state
+	Clone() Indicator
+}
+
+// window is a fixed size window of the latest values, with their running sum
+type window struct {
+	size   int
+	values []float64
+	next   int
+	sum    float64
+}
+
+func newWindow(size int) *window {
+	return &window{size: size}
+}
+
+func (w *window) add(v float64) {
+	if len(w.values) < w.size {
+		w.values = append(w.values, v)
+	} else {
+		w.sum -= w.values[w.next]
+		w.values[w.next] = v
+		w.next = (w.next + 1) % w.size
+	}
+	w.sum += v
+}
+
+func (w *window) full() bool {
+	return len(w.values) == w.size
+}
+
+func (w *window) mean() float64 {
+	return w.sum / float64(len(w.values))
+}
+
+// stddev is the population standard deviation of the window
+func (w *window) stddev() float64 {
+	mean := w.mean()
+	sq := 0.0
+	for _, v := range w.values {
+		sq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sq / float64(len(w.values)))
+}
+
+// ema is an exponential moving average, seeded with the simple average of the first period values
+type ema struct {
+	alpha float64
+	seed  *window
+	value float64
+}
+
+func newEMA(period int) *ema {
+	return &ema{alpha: 2 / float64(period+1), seed: newWindow(period)}
+}
+
+// add returns the average once the period is filled
+func (e *ema) add(v float64) (float64, bool) {
+	if !e.seed.full() {
+		e.seed.add(v)
+		if !e.seed.full() {
+			return 0, false
+		}
+		e.value = e.seed.mean()
+		return e.value, true
+	}
+	e.value += e.alpha * (v - e.value)
+	return e.value, true
+}
+
+// wilder is Wilder's smoothed average, seeded with the simple average of the first period values
+type wilder struct {
+	period int
+	seed   *window
+	value  float64
+}
+
+func newWilder(period int) *wilder {
+	return &wilder{period: period, seed: newWindow(period)}
+}
+
+func (w *wilder) add(v float64) (float64, bool) {
+	if !w.seed.full() {
+		w.seed.add(v)
+		if !w.seed.full() {
+			return 0, false
+		}
+		w.value = w.seed.mean()
+		return w.value, true
+	}
+	w.value = (w.value*float64(w.period-1) + v) / float64(w.period)
+	return w.value, true
+}
+
+// SMA is the simple moving average of the close over the period
+type SMA struct {
+	Period int
+	window *window
+}
+
+// NewSMA builds a simple moving average, setting `sma_<period>`
+func NewSMA(period int) *SMA {
+	return &SMA{Period: period, window: newWindow(period)}
+}
+
+// Fields are the names of the values the indicator sets
+func (i *SMA) Fields() []string {
+	return []string{fmt.Sprintf("sma_%d", i.Period)}
+}
+
+// Update adds the candlestick to the average
+func (i *SMA) Update(c *extractor.Candlestick, values map[string]float64) {
+	i.window.add(c.Close)
+	if i.window.full() {
+		values[i.Fields()[0]] = i.window.mean()
+	}
+}
+
+// Clone returns an indicator with the same parameters and no state
+func (i *SMA) Clone() Indicator {
+	return NewSMA(i.Period)
+}
+
+// EMA is the exponential moving average of the close over the period
+type EMA struct {
+	Period int
+	ema    *ema
+}
+
+// NewEMA builds an exponential moving average, setting `ema_<period>`
+func NewEMA(period int) *EMA {
+	return &EMA{Period: period, ema: newEMA(period)}
+}
+
+// Fields are the names of the values the indicator sets
+func (i *EMA) Fields() []string {
+	return []string{fmt.Sprintf("ema_%d", i.Period)}
+}
+
+// Update adds the candlestick to the average
+func (i *EMA) Update(c *extractor.Candlestick, values map[string]float64) {
+	if v, ok := i.ema.add(c.Close); ok {
+		values[i.Fields()[0]] = v
+	}
+}
+
+// Clone returns an indicator with the same parameters and no state
+func (i *EMA) Clone() Indicator {
+	return NewEMA(i.Period)
+}
+
+// RSI is the relative strength index of the close over the period, with Wilder's smoothing
+type RSI struct {
+	Period int
+	gain   *wilder
+	loss   *wilder
+	prev   float64
+	seen   bool
+}
+
+// NewRSI builds a relative strength index, setting `rsi_<period>`
+func NewRSI(period int) *RSI {
+	return &RSI{Period: period, gain: newWilder(period), loss: newWilder(period)}
+}
+
+// Fields are the names of the values the indicator sets
+func (i *RSI) Fields() []string {
+	return []string{fmt.Sprintf("rsi_%d", i.Period)}
+}
+
+// Update adds the change in close since the previous candlestick
+func (i *RSI) Update(c *extractor.Candlestick, values map[string]float64) {
+	if !i.seen {
+		i.prev, i.seen = c.Close, true
+		return
+	}
+	change := c.Close - i.prev
+	i.prev = c.Close
+	gain, ok := i.gain.add(math.Max(change, 0))
+	loss, _ := i.loss.add(math.Max(-change, 0))
+	if !ok {
+		return
+	}
+	if loss == 0 {
+		values[i.Fields()[0]] = 100
+		return
+	}
+	values[i.Fields()[0]] = 100 - 100/(1+gain/loss)
+}
+
+// Clone returns an indicator with the same parameters and no state
+func (i *RSI) Clone() Indicator {
+	return NewRSI(i.Period)
+}
+
+// MACD is the moving average convergence divergence of the close: the difference of the fast and slow
+// EMAs, its signal EMA, and the histogram of their difference
+type MACD struct {
+	Fast   int
+	Slow   int
+	Signal int
+	fast   *ema
+	slow   *ema
+	signal *ema
+}
+
+// NewMACD builds a MACD, setting `macd_<fast>_<slow>_<signal>`, `macd_signal_<fast>_<slow>_<signal>` and
+// `macd_hist_<fast>_<slow>_<signal>`
+func NewMACD(fast int, slow int, signal int) *MACD {
+	return &MACD{Fast: fast, Slow: slow, Signal: signal, fast: newEMA(fast), slow: newEMA(slow), signal: newEMA(signal)}
+}
+
+// Fields are the names of the values the indicator sets
+func (i *MACD) Fields() []string {
+	suffix := fmt.Sprintf("%d_%d_%d", i.Fast, i.Slow, i.Signal)
+	return []string{"macd_" + suffix, "macd_signal_" + suffix, "macd_hist_" + suffix}
+}
+
+// Update adds the candlestick to the averages. the MACD is set once the slow EMA is filled, and the
+// signal and histogram once the signal EMA is
+func (i *MACD) Update(c *extractor.Candlestick, values map[string]float64) {
+	fast, fOk := i.fast.add(c.Close)
+	slow, sOk := i.slow.add(c.Close)
+	if !fOk || !sOk {
+		return
+	}
+	fields := i.Fields()
+	macd := fast - slow
+	values[fields[0]] = macd
+	if signal, ok := i.signal.add(macd); ok {
+		values[fields[1]] = signal
+		values[fields[2]] = macd - signal
+	}
+}
+
+// Clone returns an indicator with the same parameters and no state
+func (i *MACD) Clone() Indicator {
+	return NewMACD(i.Fast, i.Slow, i.Signal)
+}
+
+// Bollinger is the Bollinger Bands of the close: its simple moving average, and the bands a number of
+// standard deviations above and below it
+type Bollinger struct {
+	Period int
+	K      float64
+	window *window
+}
+
+// NewBollinger builds Bollinger Bands, setting `bb_mid_<period>_<k>`, `bb_upper_<period>_<k>` and
+// `bb_lower_<period>_<k>`
+func NewBollinger(period int, k float64) *Bollinger {
+	return &Bollinger{Period: period, K: k, window: newWindow(period)}
+}
+
+// Fields are the names of the values the indicator sets
+func (i *Bollinger) Fields() []string {
+	suffix := fmt.Sprintf("%d_%s", i.Period, strconv.FormatFloat(i.K, 'f', -1, 64))
+	return []string{"bb_mid_" + suffix, "bb_upper_" + suffix, "bb_lower_" + suffix}
+}
+
+// Update adds the candlestick to the bands
+func (i *Bollinger) Update(c *extractor.Candlestick, values map[string]float64) {
+	i.window.add(c.Close)
+	if !i.window.full() {
+		return
+	}
+	fields := i.Fields()
+	mid, dev := i.window.mean(), i.window.stddev()
+	values[fields[0]] = mid
+	values[fields[1]] = mid + i.K*dev
+	values[fields[2]] = mid - i.K*dev
+}
+
+// Clone returns an indicator with the same parameters and no state
+func (i *Bollinger) Clone() Indicator {
+	return NewBollinger(i.Period, i.K)
+}
+
+// ATR is the average true range over the period, with Wilder's smoothing
+type ATR struct {
+	Period    int
+	avg       *wilder
+	prevClose float64
+	seen      bool
+}
+
+// NewATR builds an average true range, setting `atr_<period>`
+func NewATR(period int) *ATR {
+	return &ATR{Period: period, avg: newWilder(period)}
+}
+
+// Fields are the names of the values the indicator sets
+func (i *ATR) Fields() []string {
+	return []string{fmt.Sprintf("atr_%d", i.Period)}
+}
+
+// Update adds the true range of the candlestick. the range of the first candlestick is its high less
+// its low, as there is no previous close
+func (i *ATR) Update(c *extractor.Candlestick, values map[string]float64) {
+	tr := c.High - c.Low
+	if i.seen {
+		tr = math.Max(tr, math.Max(math.Abs(c.High-i.prevClose), math.Abs(c.Low-i.prevClose)))
+	}
+	i.prevClose, i.seen = c.Close, true
+	if v, ok := i.avg.add(tr); ok {
+		values[i.Fields()[0]] = v
+	}
+}
+
+// Clone returns an indicator with the same parameters and no state
+func (i *ATR) Clone() Indicator {
+	return NewATR(i.Period)
+}
+
+// OBV is the on-balance volume, the running total of volume signed by the direction of the close
+type OBV struct {
+	value     float64
+	prevClose float64
+	seen      bool
+}
+
+// NewOBV builds an on-balance volume, setting `obv`. the first candlestick's is zero
+func NewOBV() *OBV {
+	return &OBV{}
+}
+
+// Fields are the names of the values the indicator sets
+func (i *OBV) Fields() []string {
+	return []string{"obv"}
+}
+
+// Update adds the candlestick's volume if it closed higher, or subtracts it if it closed lower
+func (i *OBV) Update(c *extractor.Candlestick, values map[string]float64) {
+	if i.seen {
+		if c.Close > i.prevClose {
+			i.value += c.Volume
+		} else if c.Close < i.prevClose {
+			i.value -= c.Volume
+		}
+	}
+	i.prevClose, i.seen = c.Close, true
+	values["obv"] = i.value
+}
+
+// Clone returns an indicator with the same parameters and no state
+func (i *OBV) Clone() Indicator {
+	return NewOBV()
+}
+
+// Parse builds indicators from a comma separated list of `name[:param...]`, for example
+// `sma:20,ema:12,rsi:14,macd:12:26:9,bb:20:2,atr:14,obv`. parameters left out are given their usual
+// defaults: sma 20, ema 20, rsi 14, macd 12:26:9, bb 20:2 and atr 14
+func Parse(spec string) ([]Indicator, error) {
+	var inds []Indicator
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		parts := strings.Split(s, ":")
+		name, params := strings.ToLower(parts[0]), parts[1:]
+
+		var ind Indicator
+		var p []int
+		var err error
+		switch name {
+		case "sma":
+			if p, err = intParams(s, params, 20); err == nil {
+				ind = NewSMA(p[0])
+			}
+		case "ema":
+			if p, err = intParams(s, params, 20); err == nil {
+				ind = NewEMA(p[0])
+			}
+		case "rsi":
+			if p, err = intParams(s, params, 14); err == nil {
+				ind = NewRSI(p[0])
+			}
+		case "macd":
+			if p, err = intParams(s, params, 12, 26, 9); err == nil && p[0] >= p[1] {
+				err = fmt.Errorf("Invalid indicator [%s]: the fast period must be shorter than the slow", s)
+			}
+			if err == nil {
+				ind = NewMACD(p[0], p[1], p[2])
+			}
+		case "bb":
+			// the second parameter is the number of standard deviations, which needn't be whole
+			k := 2.0
+			if len(params) == 2 {
+				if k, err = strconv.ParseFloat(params[1], 64); err != nil || k <= 0 {
+					return nil, fmt.Errorf("Invalid indicator [%s]: expected a positive number of standard deviations [%s]", s, params[1])
+				}
+				params = params[:1]
+			}
+			if p, err = intParams(s, params, 20); err == nil {
+				ind = NewBollinger(p[0], k)
+			}
+		case "atr":
+			if p, err = intParams(s, params, 14); err == nil {
+				ind = NewATR(p[0])
+			}
+		case "obv":
+			if len(params) > 0 {
+				err = fmt.Errorf("Invalid indicator [%s]: obv takes no parameters", s)
+			}
+			ind = NewOBV()
+		default:
+			err = fmt.Errorf("Unknown indicator [%s], expected one of [sma, ema, rsi, macd, bb, atr, obv]", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		inds = append(inds, ind)
+	}
+	return inds, nil
+}
+
+// intParams parses the positive integer parameters of an indicator, using the defaults for those left out
+func intParams(spec string, params []string, defaults ...int) ([]int, error) {
+	if len(params) > len(defaults) {
+		return nil, fmt.Errorf("Invalid indicator [%s]: expected at most %d parameters", spec, len(defaults))
+	}
+	out := append([]int{}, defaults...)
+	for i, p := range params {
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("Invalid indicator [%s]: expected a positive integer period [%s]", spec, p)
+		}
+		out[i] = n
+	}
+	return out, nil
+}
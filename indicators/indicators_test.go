@@ -0,0 +1,229 @@
+package indicators_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/indicators"
+)
+
+var testStart = time.Date(2017, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+// bars are small enough to work the expected values out by hand
+var bars = []struct {
+	high, low, close, volume float64
+}{
+	{3, 1, 2, 1},
+	{5, 3, 4, 2},
+	{7, 5, 6, 3},
+	{9, 6, 8, 4},
+	{8, 3, 4, 5},
+	{7, 4, 6, 6},
+	{6.5, 5.5, 6, 7},
+}
+
+// series builds a minute candlestick from each bar, in order
+func series(product string) []*extractor.Candlestick {
+	var cdls []*extractor.Candlestick
+	for i, b := range bars {
+		cdls = append(cdls, &extractor.Candlestick{
+			Product:     product,
+			Source:      "gdax",
+			Granularity: 60,
+			Timestamp:   testStart.Add(time.Duration(i) * time.Minute).Unix(),
+			High:        b.high,
+			Low:         b.low,
+			Close:       b.close,
+			Volume:      b.volume,
+		})
+	}
+	return cdls
+}
+
+// closes builds a minute candlestick with each close
+func closes(values ...float64) []*extractor.Candlestick {
+	var cdls []*extractor.Candlestick
+	for i, v := range values {
+		cdls = append(cdls, &extractor.Candlestick{
+			Product:     "BTC-USD",
+			Granularity: 60,
+			Timestamp:   testStart.Add(time.Duration(i) * time.Minute).Unix(),
+			Close:       v,
+		})
+	}
+	return cdls
+}
+
+// none marks a candlestick the field isn't set for, during the warm-up period
+var none = math.NaN()
+
+// assertField fails unless the field of each candlestick is the expected value, or unset where none is
+// expected
+func assertField(t *testing.T, cdls []*extractor.Candlestick, field string, want []float64) {
+	t.Helper()
+	if len(cdls) != len(want) {
+		t.Fatalf("%s: expected %d candlesticks, got %d", field, len(want), len(cdls))
+	}
+	for i, c := range cdls {
+		got, ok := c.Fields[field]
+		if math.IsNaN(want[i]) {
+			if ok {
+				t.Errorf("%s: expected candlestick %d to be unset in the warm-up, got %f", field, i, got)
+			}
+			continue
+		}
+		if !ok || math.Abs(got-want[i]) > 1e-9 {
+			t.Errorf("%s: expected candlestick %d to be %f, got %f (set %t)", field, i, want[i], got, ok)
+		}
+	}
+}
+
+// enrich passes the candlesticks through an enricher of the indicators
+func enrich(t *testing.T, cdls []*extractor.Candlestick, inds ...indicators.Indicator) []*extractor.Candlestick {
+	t.Helper()
+	e := indicators.New(inds...)
+	var out []*extractor.Candlestick
+	for _, c := range cdls {
+		res, err := e.Apply(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, res...)
+	}
+	return out
+}
+
+func TestMovingAverages(t *testing.T) {
+	out := enrich(t, series("BTC-USD"), indicators.NewSMA(3), indicators.NewEMA(3))
+	assertField(t, out, "sma_3", []float64{none, none, 4, 6, 6, 6, 16.0 / 3})
+	// alpha is 2/(3+1), seeded with the simple average of the first three closes
+	assertField(t, out, "ema_3", []float64{none, none, 4, 6, 5, 5.5, 5.75})
+}
+
+func TestMACD(t *testing.T) {
+	out := enrich(t, series("BTC-USD"), indicators.NewMACD(2, 3, 2))
+	// the fast EMA is 3, 5, 7, 5, 17/3 and 53/9 from the second candlestick, and the slow is as above
+	assertField(t, out, "macd_2_3_2", []float64{none, none, 1, 1, 0, 1.0 / 6, 5.0 / 36})
+	assertField(t, out, "macd_signal_2_3_2", []float64{none, none, none, 1, 1.0 / 3, 2.0 / 9, 1.0 / 6})
+	assertField(t, out, "macd_hist_2_3_2", []float64{none, none, none, 0, -1.0 / 3, -1.0 / 18, -1.0 / 36})
+}
+
+func TestBollinger(t *testing.T) {
+	out := enrich(t, series("BTC-USD"), indicators.NewBollinger(3, 2))
+	// the population standard deviation of each window: 2, 4, 6 and its shifts deviate by sqrt(8/3)
+	dev := 2 * math.Sqrt(8.0/3)
+	last := 2 * math.Sqrt(8.0/9)
+	assertField(t, out, "bb_mid_3_2", []float64{none, none, 4, 6, 6, 6, 16.0 / 3})
+	assertField(t, out, "bb_upper_3_2", []float64{none, none, 4 + dev, 6 + dev, 6 + dev, 6 + dev, 16.0/3 + last})
+	assertField(t, out, "bb_lower_3_2", []float64{none, none, 4 - dev, 6 - dev, 6 - dev, 6 - dev, 16.0/3 - last})
+}
+
+func TestATR(t *testing.T) {
+	out := enrich(t, series("BTC-USD"), indicators.NewATR(3))
+	// the true ranges are 2, 3, 3, 3, 5, 3 and 1. the first is the high less the low, as there's no
+	// previous close, and the others reach back to it where it's outside the candlestick
+	assertField(t, out, "atr_3", []float64{none, none, 8.0 / 3, 25.0 / 9, 95.0 / 27, 271.0 / 81, 623.0 / 243})
+}
+
+func TestOBV(t *testing.T) {
+	out := enrich(t, series("BTC-USD"), indicators.NewOBV())
+	// the volume is added when the close rises, subtracted when it falls, and ignored when it's unchanged
+	assertField(t, out, "obv", []float64{0, 2, 5, 9, 4, 10, 10})
+}
+
+func TestRSI(t *testing.T) {
+	// the StockCharts RSI example. their table rounds the intermediate averages, giving 70.53 for the first
+	rsi := []float64{none, none, none, none, none, none, none, none, none, none, none, none, none, none,
+		70.464135, 66.249619, 66.480942, 69.346853, 66.294713, 57.915021, 62.880718, 63.208789, 56.011585,
+		62.339929, 54.670971, 50.386815, 40.019424, 41.492635, 41.902430, 45.499497, 37.322778, 33.090483,
+		37.788772}
+	out := enrich(t, closes(44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08, 45.89,
+		46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64, 46.21, 46.25, 45.71, 46.45, 45.78,
+		45.35, 44.03, 44.18, 44.22, 44.57, 43.42, 42.66, 43.13), indicators.NewRSI(14))
+	for i, c := range out {
+		got, ok := c.Fields["rsi_14"]
+		if math.IsNaN(rsi[i]) != !ok || (ok && math.Abs(got-rsi[i]) > 1e-6) {
+			t.Errorf("candlestick %d: expected %f, got %f (set %t)", i, rsi[i], got, ok)
+		}
+	}
+
+	// without a loss over the period, the index is 100
+	out = enrich(t, closes(1, 2, 3, 4), indicators.NewRSI(3))
+	assertField(t, out, "rsi_3", []float64{none, none, none, 100})
+}
+
+func TestParse(t *testing.T) {
+	inds, err := indicators.Parse("sma:10, EMA ,rsi:7,macd:5:10:3,bb:15:2.5,atr,obv,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"sma_10", "ema_20", "rsi_7",
+		"macd_5_10_3", "macd_signal_5_10_3", "macd_hist_5_10_3",
+		"bb_mid_15_2.5", "bb_upper_15_2.5", "bb_lower_15_2.5",
+		"atr_14", "obv",
+	}
+	if got := indicators.New(inds...).Fields(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected fields %v, got %v", want, got)
+	}
+
+	for _, spec := range []string{
+		"wma:10",
+		"sma:0",
+		"sma:-5",
+		"sma:ten",
+		"sma:10:20",
+		"ema:1.5",
+		"rsi:14:2",
+		"macd:26:12:9",
+		"macd:12:12:9",
+		"macd:12:26:9:1",
+		"bb:20:0",
+		"bb:20:two",
+		"bb:20:2:1",
+		"atr:",
+		"obv:10",
+	} {
+		if inds, err := indicators.Parse(spec); err == nil {
+			t.Errorf("expected [%s] to be rejected, got %d indicators", spec, len(inds))
+		}
+	}
+}
+
+func TestEnricherSeries(t *testing.T) {
+	e := indicators.New(indicators.NewSMA(3))
+	btc, eth := series("BTC-USD"), series("ETH-USD")
+	hourly := *btc[3]
+	hourly.Granularity = 3600
+	var out []*extractor.Candlestick
+	// interleaved products, with an ETH candlestick out of order, a BTC duplicate, and the same BTC
+	// timestamp at another granularity
+	for _, c := range []*extractor.Candlestick{
+		btc[0], eth[0], btc[1], eth[2], btc[2], eth[1], btc[3], btc[3], eth[3], &hourly,
+	} {
+		res, err := e.Apply(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 1 {
+			t.Fatalf("expected a single candlestick, got %d", len(res))
+		}
+		out = append(out, res...)
+	}
+
+	// each product and granularity is averaged separately. the late ETH candlestick is passed on without
+	// a value, and left out of the average
+	assertField(t, out, "sma_3", []float64{none, none, none, none, 4, none, 6, none, 16.0 / 3, none})
+	if out[5] != eth[1] || out[7] != btc[3] {
+		t.Error("expected the late candlesticks to be passed on unchanged")
+	}
+	if out[9] == &hourly {
+		t.Error("expected the hourly candlestick to start its own series rather than be passed on as late")
+	}
+	if len(btc[2].Fields) != 0 {
+		t.Error("expected the original candlestick to be left unchanged")
+	}
+}
@@ -1,18 +1,36 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/indicators"
 	"github.com/johnhof/gdax-candle-extractor/receivers"
+	"github.com/johnhof/gdax-candle-extractor/replay"
+	"github.com/johnhof/gdax-candle-extractor/resample"
+	"github.com/johnhof/gdax-candle-extractor/transforms"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
-type Log struct{}
+// Log prints to stdout, redacting any secrets from the output
+type Log struct {
+	secrets []string
+}
 
 func (l Log) Printf(format string, v ...interface{}) {
-	fmt.Printf(format, v...)
+	out := fmt.Sprintf(format, v...)
+	for _, s := range l.secrets {
+		if s != "" {
+			out = strings.Replace(out, s, extractor.Redacted, -1)
+		}
+	}
+	fmt.Print(out)
 }
 
 var (
@@ -21,30 +39,111 @@ var (
 	verbose = kingpin.Flag("verbose", "verbose logging").Short('v').
 		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_VERBOSE").
 		Default("false").Bool()
-	key = kingpin.Flag("key", "GDAX API key").Short('k').
+	key = kingpin.Flag("key", "GDAX API key (optional)").Short('k').
 		OverrideDefaultFromEnvar("GDAX_API_KEY").
-		Required().String()
-	secret = kingpin.Flag("secret", "GDAX API secret").Short('s').
+		Default("").String()
+	secret = kingpin.Flag("secret", "GDAX API secret (optional)").Short('s').
 		OverrideDefaultFromEnvar("GDAX_API_SECRET").
-		Required().String()
-	passphrase = kingpin.Flag("passphrase", "GDAX API passphrase").Short('p').
+		Default("").String()
+	passphrase = kingpin.Flag("passphrase", "GDAX API passphrase (optional)").Short('p').
 			OverrideDefaultFromEnvar("GDAX_API_PASSPHRASE").
-			Required().String()
-	product = kingpin.Flag("product", "Product ID to extract [BTC-USD, ETH-USD, LTC-USD]").
+			Default("").String()
+	secretFile = kingpin.Flag("secret-file", "Read the GDAX API secret from a file, or stdin if -").
+			OverrideDefaultFromEnvar("GDAX_API_SECRET_FILE").
+			Default("").String()
+	credentialsFile = kingpin.Flag("credentials-file", "Read the GDAX API key, secret and passphrase from a JSON or YAML file, which must have 0600 permissions").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_CREDENTIALS_FILE").
+			Default("").String()
+	baseURL = kingpin.Flag("base-url", "Override the GDAX API URL, e.g. to use the sandbox or a mock server").
+		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_BASE_URL").
+		Default("").String()
+	product = kingpin.Flag("product", "Comma separated product IDs to extract [BTC-USD, ETH-USD, LTC-USD]").
 		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_PRODUCT").
 		Required().String()
 	granularity = kingpin.Flag("granularity", "Granularity in seconds of blocks in the candlestick data").Short('G').
 			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_GRANULARITY").
 			Default("86400").Int()
+	order = kingpin.Flag("order", "Order of the candlesticks for each product [asc, desc, received]").
+		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_ORDER").
+		Default("asc").Enum("asc", "desc", "received")
+	resampleTo = kingpin.Flag("resample-to", "Resample candlesticks to a coarser granularity in seconds, which must be a multiple of the granularity").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_RESAMPLE_TO").
+			Default("0").Int()
+	aggregate = kingpin.Flag("aggregate", "Allow granularities GDAX does not serve (e.g. 7200, 604800), aggregated from the largest supported granularity which divides them").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_AGGREGATE").
+			Default("false").Bool()
+	dropZeroVolume = kingpin.Flag("drop-zero-volume", "Drop candlesticks without any trades before they're written").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_DROP_ZERO_VOLUME").
+			Default("false").Bool()
+	round = kingpin.Flag("round", "Round prices and volume to the number of decimal places before they're written. disabled if negative").
+		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_ROUND").
+		Default("-1").Int()
+	keepFields = kingpin.Flag("keep-fields", "Comma separated candlestick fields to keep, clearing the others to zero. every column is still written, and product, granularity and timestamp must be kept [product, source, datetime, granularity, low, high, open, close, volume, timestamp]").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_KEEP_FIELDS").
+			Default("").String()
+	indicatorSpec = kingpin.Flag("indicators", "Comma separated technical indicators to compute and write with each candlestick, e.g. sma:20,ema:12,rsi:14,macd:12:26:9,bb:20:2,atr:14,obv").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_INDICATORS").
+			Default("").String()
 	bufferSize = kingpin.Flag("buffer-size", "Size of candlestick buffer waiting for collection").Short('b').
 			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_BUFFER_SIZE").
 			Default("100").Int()
-	start = kingpin.Flag("start", "Start time as RFC3339").Short('S').
+	receiverBuffer = kingpin.Flag("receiver-buffer", "Size of the candlestick buffer of each receiver").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_RECEIVER_BUFFER").
+			Default("100").Int()
+	backpressure = kingpin.Flag("backpressure", "Policy applied when a receiver's buffer is full [block, drop-oldest, drop-newest, spill]").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_BACKPRESSURE").
+			Default("block").Enum("block", "drop-oldest", "drop-newest", "spill")
+	spillDir = kingpin.Flag("spill-dir", "Directory receivers spill candlesticks to with --backpressure=spill. defaults to the OS temp directory").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_SPILL_DIR").
+			Default("").String()
+	onError = kingpin.Flag("on-error", "Policy applied when a receiver fails to write a candlestick [report, fail-fast, retry, dead-letter]").
+		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_ON_ERROR").
+		Default("report").Enum("report", "fail-fast", "retry", "dead-letter")
+	deadLetterFile = kingpin.Flag("dead-letter-file", "Set the newline delimited JSON file failed candlesticks are written to with --on-error=dead-letter or retry").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_DEAD_LETTER_FILE").
+			Default("dead-letter.ndjson").String()
+	batchSize = kingpin.Flag("batch-size", "Maximum number of candlesticks written at once by receivers which support batching").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_BATCH_SIZE").
+			Default("500").Int()
+	batchLatency = kingpin.Flag("batch-latency", "Maximum time a candlestick waits for its batch to fill before a partial batch is written").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_BATCH_LATENCY").
+			Default("1s").Duration()
+	rateLimit = kingpin.Flag("rate-limit", "Maximum number of requests per second made to the GDAX API").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_RATE_LIMIT").
+			Default("3").Float64()
+	rateBurst = kingpin.Flag("rate-burst", "Number of requests which may be made back to back before the rate limit applies").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_RATE_BURST").
+			Default("1").Int()
+	retryAttempts = kingpin.Flag("retry-attempts", "Maximum number of requests made for a range before reporting an error").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_RETRY_ATTEMPTS").
+			Default("5").Int()
+	retryMaxDelay = kingpin.Flag("retry-max-delay", "Maximum wait between retries of a failed range, 0 for no limit").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_RETRY_MAX_DELAY").
+			Default("30s").Duration()
+	detectGaps = kingpin.Flag("detect-gaps", "Report candlesticks missing from the data returned by GDAX").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_DETECT_GAPS").
+			Default("false").Bool()
+	refetchGaps = kingpin.Flag("refetch-gaps", "Request missing candlesticks again before reporting them. Implies --detect-gaps").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_REFETCH_GAPS").
+			Default("false").Bool()
+	checkpoint = kingpin.Flag("checkpoint", "Record extraction progress to the checkpoint file").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_CHECKPOINT").
+			Default("false").Bool()
+	checkpointFile = kingpin.Flag("checkpoint-file", "Set the checkpoint file to record progress to").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_CHECKPOINT_FILE").
+			Default("checkpoint.json").String()
+	resume = kingpin.Flag("resume", "Resume from the checkpoint file, appending to existing output files. Implies --checkpoint").
+		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_RESUME").
+		Default("false").Bool()
+	replayFiles = kingpin.Flag("replay", "Comma separated CSV, JSON or newline delimited JSON files to replay in place of extracting from GDAX. Filtered by product, granularity, start and end").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_REPLAY").
+			Default("").String()
+	start = kingpin.Flag("start", "Start time as RFC3339. defaults to a week ago, or the start of the files when replaying").Short('S').
 		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_START").
-		Default(now.Add(-24 * 7 * time.Hour).Format(timeFmt)).String()
-	end = kingpin.Flag("end", "End time in as RFC3339").Short('E').
+		Default("").String()
+	end = kingpin.Flag("end", "End time in as RFC3339. defaults to now, or the end of the files when replaying").Short('E').
 		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_END").
-		Default(now.Format(timeFmt)).String()
+		Default("").String()
 
 	outStd = kingpin.Flag("out-stdout", "Write output to stdout. Used by default if no other output is specified").
 		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_STDOUT").
@@ -71,6 +170,95 @@ var (
 			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_ND_JSON_FILE").
 			Default("out.ndjson").String()
 
+	outParquet = kingpin.Flag("out-parquet", "Write output to Parquet file").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_PARQUET").
+			Default("false").Bool()
+	outParquetFile = kingpin.Flag("out-parquet-file", "Set the file to write to").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_PARQUET_FILE").
+			Default("out.parquet").String()
+	outParquetRowGroup = kingpin.Flag("out-parquet-row-group", "Number of candlesticks in each Parquet row group, buffered in memory until written").
+				OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_PARQUET_ROW_GROUP").
+				Default("100000").Int()
+	outParquetCompression = kingpin.Flag("out-parquet-compression", "Compression of the Parquet file [snappy, zstd, gzip, none]").
+				OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_PARQUET_COMPRESSION").
+				Default("snappy").Enum("snappy", "zstd", "gzip", "none")
+
+	outSQLite = kingpin.Flag("out-sqlite", "Upsert output to a SQLite database").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_SQLITE").
+			Default("false").Bool()
+	outSQLiteFile = kingpin.Flag("out-sqlite-file", "Set the database file to write to").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_SQLITE_FILE").
+			Default("out.db").String()
+	outSQLiteTable = kingpin.Flag("out-sqlite-table", "Set the SQLite table to write to, created if it doesn't exist").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_SQLITE_TABLE").
+			Default("candles").String()
+	outSQLiteBatch = kingpin.Flag("out-sqlite-batch", "Most candlesticks written to SQLite in a single transaction").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_SQLITE_BATCH").
+			Default("500").Int()
+
+	outPG = kingpin.Flag("out-pg", "Upsert output to a Postgres or TimescaleDB database").
+		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_PG").
+		Default("false").Bool()
+	outPGDSN = kingpin.Flag("out-pg-dsn", "Set the Postgres connection string, as a URL or key/value pairs").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_PG_DSN").
+			Default("postgres://postgres@localhost:5432/postgres?sslmode=disable").String()
+	outPGTable = kingpin.Flag("out-pg-table", "Set the Postgres table to write to, created if it doesn't exist").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_PG_TABLE").
+			Default("candles").String()
+	outPGHypertable = kingpin.Flag("out-pg-hypertable", "Create the Postgres table as a TimescaleDB hypertable partitioned by time").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_PG_HYPERTABLE").
+			Default("false").Bool()
+	outPGCopy = kingpin.Flag("out-pg-copy", "Bulk-load each batch with COPY into a staging table, rather than a multi-row INSERT").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_PG_COPY").
+			Default("false").Bool()
+	outPGBatch = kingpin.Flag("out-pg-batch", "Most candlesticks written to Postgres in a single statement").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_PG_BATCH").
+			Default("500").Int()
+
+	outLP = kingpin.Flag("out-lp", "Write output to an InfluxDB line protocol file").
+		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_LP").
+		Default("false").Bool()
+	outLPFile = kingpin.Flag("out-lp-file", "Set the file to write to").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_LP_FILE").
+			Default("out.lp").String()
+
+	outInflux = kingpin.Flag("out-influx", "Write output to InfluxDB").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX").
+			Default("false").Bool()
+	outInfluxURL = kingpin.Flag("out-influx-url", "Set the InfluxDB server to write to").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_URL").
+			Default("http://localhost:8086").String()
+	outInfluxVersion = kingpin.Flag("out-influx-version", "Version of the InfluxDB write API [1, 2]").
+				OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_VERSION").
+				Default("2").Enum("1", "2")
+	outInfluxDB = kingpin.Flag("out-influx-db", "Set the InfluxDB database to write to with the v1 API").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_DB").
+			Default("").String()
+	outInfluxRP = kingpin.Flag("out-influx-rp", "Set the InfluxDB retention policy to write to with the v1 API. the default policy is used if empty").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_RP").
+			Default("").String()
+	outInfluxOrg = kingpin.Flag("out-influx-org", "Set the InfluxDB org to write to with the v2 API").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_ORG").
+			Default("").String()
+	outInfluxBucket = kingpin.Flag("out-influx-bucket", "Set the InfluxDB bucket to write to with the v2 API").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_BUCKET").
+			Default("").String()
+	outInfluxToken = kingpin.Flag("out-influx-token", "Set the InfluxDB API token").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_TOKEN").
+			Default("").String()
+	outInfluxUser = kingpin.Flag("out-influx-user", "Set the InfluxDB basic auth username, for the v1 API").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_USER").
+			Default("").String()
+	outInfluxPassword = kingpin.Flag("out-influx-password", "Set the InfluxDB basic auth password, for the v1 API").
+				OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_PASSWORD").
+				Default("").String()
+	outInfluxGzip = kingpin.Flag("out-influx-gzip", "Compress InfluxDB requests with gzip").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_GZIP").
+			Default("false").Bool()
+	outInfluxMeasurement = kingpin.Flag("out-influx-measurement", "Set the measurement written to InfluxDB and the line protocol file").
+				OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_INFLUX_MEASUREMENT").
+				Default("candles").String()
+
 	outES = kingpin.Flag("out-es", "Index output to elasticsearch").
 		OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_ES").
 		Default("false").Bool()
@@ -86,63 +274,260 @@ var (
 	outESSecure = kingpin.Flag("out-es-secure", "Set the elasticsearch requests to use https").
 			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_SECURE").
 			Default("false").Bool()
+	outESUser = kingpin.Flag("out-es-user", "Set the elasticsearch basic auth username").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_ES_USER").
+			Default("").String()
+	outESPassword = kingpin.Flag("out-es-password", "Set the elasticsearch basic auth password").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_ES_PASSWORD").
+			Default("").String()
+	outESAPIKey = kingpin.Flag("out-es-api-key", "Set the elasticsearch API key, the base64 encoded id:api_key").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_ES_API_KEY").
+			Default("").String()
+	outESCACert = kingpin.Flag("out-es-ca-cert", "Set a PEM encoded CA certificate to verify the elasticsearch cluster with").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_ES_CA_CERT").
+			Default("").String()
+	outESTemplate = kingpin.Flag("out-es-template", "Install an index template mapping timestamp as a date and prices as scaled floats").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_ES_TEMPLATE").
+			Default("false").Bool()
+	outESTypes = kingpin.Flag("out-es-types", "Index each granularity as a mapping type, for elasticsearch 5, or 6 with a single granularity per index").
+			OverrideDefaultFromEnvar("GDAX_EXTRACTOR_OUT_ES_TYPES").
+			Default("false").Bool()
 )
 
 func main() {
 	kingpin.Version("1.1.1")
 	kingpin.Parse()
+	creds := loadCredentials()
 	if *verbose {
-		printVars()
+		printVars(creds)
+	}
+
+	retry := extractor.DefaultRetryConfig()
+	retry.MaxAttempts = *retryAttempts
+	retry.MaxDelay = *retryMaxDelay
+
+	startTime, endTime := timeRange(*replayFiles != "")
+
+	var cpStore extractor.CheckpointStore
+	if *checkpoint || *resume {
+		cpStore = extractor.NewFileCheckpoint(*checkpointFile)
+	}
+
+	// secrets are redacted from anything logged by the extractor, and the errors and gaps the collector prints
+	secrets := []string{
+		creds.Key, creds.Secret, creds.Passphrase,
+		*outESPassword, *outESAPIKey,
+		receivers.DSNPassword(*outPGDSN),
+		*outInfluxToken, *outInfluxPassword,
 	}
+	logger := Log{secrets: secrets}
 
 	xtrct := extractor.New(&extractor.ExtractorConfig{
-		Key:        *key,
-		Secret:     *secret,
-		Passphrase: *passphrase,
-		BufferSize: *bufferSize,
-		Logger:     Log{},
+		Credentials: creds,
+		BaseURL:     *baseURL,
+		BufferSize:  *bufferSize,
+		Logger:      logger,
+		Retry:       retry,
+		RateLimiter: extractor.NewTokenBucket(*rateLimit, *rateBurst),
+		Checkpoint:  cpStore,
+		Resume:      *resume,
+		DetectGaps:  *detectGaps,
+		RefetchGaps: *refetchGaps,
+		Order:       parseOrder(*order),
 		Extraction: &extractor.ExtractionConfig{
-			Product:     *product,
-			Start:       parseTime(*start),
-			End:         parseTime(*end),
+			Products:    parseList(*product),
+			Start:       startTime,
+			End:         endTime,
 			Granularity: *granularity,
+			Aggregate:   *aggregate,
 		},
 	})
 
 	fmt.Print("\nExtracting...\n\n")
 	started := time.Now()
 
-	err := xtrct.Start()
+	// cancel extraction on interrupt, letting the receivers close cleanly
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	// Replay previously written files in place of extraction if set
+	var src extractor.Collectable
+	var err error
+	if *replayFiles != "" {
+		rp := replay.New(&replay.Config{
+			Paths:       parseList(*replayFiles),
+			Products:    parseList(*product),
+			Granularity: *granularity,
+			Start:       startTime,
+			End:         endTime,
+			BufferSize:  *bufferSize,
+		})
+		err = rp.StartContext(ctx)
+		src = rp
+	} else {
+		err = xtrct.StartContext(ctx)
+		src = xtrct
+	}
 	check(err)
 
+	// Resample the extracted candlesticks to a coarser granularity if set
+	if *resampleTo > 0 {
+		src, err = resample.New(src, &resample.Config{
+			Granularity: *resampleTo,
+			BufferSize:  *bufferSize,
+		})
+		check(err)
+	}
+
+	// Filter and modify candlesticks before they're passed to the receivers
+	var tfs []extractor.Transform
+	if *dropZeroVolume {
+		tfs = append(tfs, transforms.NewDropZeroVolume())
+	}
+	if *round >= 0 {
+		tf, err := transforms.NewRound(*round)
+		check(err)
+		tfs = append(tfs, tf)
+	}
+	// Compute indicators over the rounded values, before any fields are cleared
+	var indicatorFields []string
+	if *indicatorSpec != "" {
+		inds, err := indicators.Parse(*indicatorSpec)
+		check(err)
+		enrich := indicators.New(inds...)
+		indicatorFields = enrich.Fields()
+		tfs = append(tfs, enrich)
+	}
+	if *keepFields != "" {
+		tf, err := transforms.NewClearFields(parseList(*keepFields)...)
+		check(err)
+		tfs = append(tfs, tf)
+	}
+
 	collector := extractor.NewCollector(&extractor.CollectorConfig{
-		Extractor: xtrct,
+		Extractor:  src,
+		Transforms: tfs,
+		Logger:     logger,
+		Delivery: &extractor.DeliveryConfig{
+			BufferSize:     *receiverBuffer,
+			Backpressure:   parseBackpressure(*backpressure),
+			SpillDir:       *spillDir,
+			BatchSize:      *batchSize,
+			BatchLatency:   *batchLatency,
+			OnError:        parseErrorPolicy(*onError),
+			DeadLetterFile: *deadLetterFile,
+		},
 	})
 
 	// Write out to a CSV file
 	if *outCSV {
-		rcv, err := receivers.NewCSV(*outCSVFile)
+		rcv, err := receivers.NewCSVWithFields(*outCSVFile, indicatorFields, *resume)
 		check(err)
 		collector.Add(rcv)
 	}
 
 	// Write out to a JSON file
 	if *outJSON {
-		rcv, err := receivers.NewJSON(*outJSONFile)
+		rcv, err := receivers.NewJSON(*outJSONFile, *resume)
 		check(err)
 		collector.Add(rcv)
 	}
 
 	// Write out to a newline delimited JSON file
 	if *outNDJSON {
-		rcv, err := receivers.NewNDJSON(*outNDJSONFile)
+		rcv, err := receivers.NewNDJSON(*outNDJSONFile, *resume)
+		check(err)
+		collector.Add(rcv)
+	}
+
+	// Write out to a Parquet file, which is finalized when the collection ends
+	if *outParquet {
+		if *resume {
+			check(fmt.Errorf("Parquet files can't be appended to, --resume can't be used with --out-parquet"))
+		}
+		rcv, err := receivers.NewParquetWithConfig(&receivers.ParquetConfig{
+			Path:         *outParquetFile,
+			RowGroupSize: *outParquetRowGroup,
+			Compression:  parseParquetCompression(*outParquetCompression),
+			Fields:       indicatorFields,
+		})
+		check(err)
+		collector.Add(rcv)
+	}
+
+	// Upsert to a SQLite database
+	if *outSQLite {
+		rcv, err := receivers.NewSQLiteWithConfig(&receivers.SQLiteConfig{
+			Path:      *outSQLiteFile,
+			Table:     *outSQLiteTable,
+			BatchSize: *outSQLiteBatch,
+		})
+		check(err)
+		collector.Add(rcv)
+	}
+
+	// Upsert to a Postgres or TimescaleDB database
+	if *outPG {
+		rcv, err := receivers.NewPostgresWithConfig(&receivers.PGConfig{
+			DSN:        *outPGDSN,
+			Table:      *outPGTable,
+			Hypertable: *outPGHypertable,
+			Copy:       *outPGCopy,
+			BatchSize:  *outPGBatch,
+		})
+		check(err)
+		collector.Add(rcv)
+	}
+
+	// Write out to an InfluxDB line protocol file
+	if *outLP {
+		rcv, err := receivers.NewLineProtocol(*outLPFile, *outInfluxMeasurement, *resume)
+		check(err)
+		collector.Add(rcv)
+	}
+
+	// Write to InfluxDB
+	if *outInflux {
+		version := 2
+		if *outInfluxVersion == "1" {
+			version = 1
+		}
+		rcv, err := receivers.NewInfluxWithConfig(&receivers.InfluxConfig{
+			URL:             *outInfluxURL,
+			Version:         version,
+			Database:        *outInfluxDB,
+			RetentionPolicy: *outInfluxRP,
+			Org:             *outInfluxOrg,
+			Bucket:          *outInfluxBucket,
+			Token:           *outInfluxToken,
+			Username:        *outInfluxUser,
+			Password:        *outInfluxPassword,
+			Measurement:     *outInfluxMeasurement,
+			Gzip:            *outInfluxGzip,
+		})
 		check(err)
 		collector.Add(rcv)
 	}
 
 	// Index to elasticsearch
 	if *outES {
-		rcv, err := receivers.NewElasticsearch(*outESIdx, *outESHost, *outESPort, *outESSecure)
+		rcv, err := receivers.NewElasticsearchWithConfig(&receivers.ESConfig{
+			Index:    *outESIdx,
+			Host:     *outESHost,
+			Port:     *outESPort,
+			Secure:   *outESSecure,
+			Username: *outESUser,
+			Password: *outESPassword,
+			APIKey:   *outESAPIKey,
+			CACert:   *outESCACert,
+			Types:    *outESTypes,
+			Template: *outESTemplate,
+		})
 		check(err)
 		collector.Add(rcv)
 	}
@@ -152,7 +537,18 @@ func main() {
 		collector.Add(receivers.NewStdout())
 	}
 
-	err = collector.Collect()
+	err = collector.CollectContext(ctx)
+	if *verbose {
+		printStats(collector.Stats())
+	}
+	if err == context.Canceled {
+		fmt.Printf("\n...Cancelled after %s\n", time.Since(started).String())
+		os.Exit(1)
+	}
+	if cErr, ok := err.(*extractor.CollectionError); ok {
+		fmt.Printf("\n...Failed after %s: %s\n", time.Since(started).String(), cErr.Error())
+		os.Exit(1)
+	}
 	check(err)
 	fmt.Printf("\n...Done in %s\n", time.Since(started).String())
 }
@@ -167,6 +563,23 @@ func check(e error) {
 	}
 }
 
+// timeRange returns the start and end of the extraction, defaulting to the last week. when replaying, an
+// unset start or end is left zero, so the files aren't filtered by it
+func timeRange(replaying bool) (time.Time, time.Time) {
+	var s, e time.Time
+	if *start != "" {
+		s = parseTime(*start)
+	} else if !replaying {
+		s = now.Add(-24 * 7 * time.Hour)
+	}
+	if *end != "" {
+		e = parseTime(*end)
+	} else if !replaying {
+		e = now
+	}
+	return s, e
+}
+
 func parseTime(date string) time.Time {
 	t, err := time.Parse(timeFmt, date)
 	if err != nil {
@@ -175,15 +588,155 @@ func parseTime(date string) time.Time {
 	return t
 }
 
-func printVars() {
+// formatTime formats the time for output, or describes a zero time as unset
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "unset"
+	}
+	return t.Format(timeFmt)
+}
+
+func parseList(list string) []string {
+	var ps []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+func parseOrder(o string) extractor.Order {
+	switch o {
+	case "desc":
+		return extractor.OrderDescending
+	case "received":
+		return extractor.OrderAsReceived
+	}
+	return extractor.OrderAscending
+}
+
+func parseBackpressure(b string) extractor.Backpressure {
+	switch b {
+	case "drop-oldest":
+		return extractor.BackpressureDropOldest
+	case "drop-newest":
+		return extractor.BackpressureDropNewest
+	case "spill":
+		return extractor.BackpressureSpill
+	}
+	return extractor.BackpressureBlock
+}
+
+func parseParquetCompression(c string) receivers.ParquetCompression {
+	switch c {
+	case "zstd":
+		return receivers.ParquetZstd
+	case "gzip":
+		return receivers.ParquetGzip
+	case "none":
+		return receivers.ParquetUncompressed
+	}
+	return receivers.ParquetSnappy
+}
+
+func parseErrorPolicy(p string) extractor.ErrorPolicy {
+	switch p {
+	case "fail-fast":
+		return extractor.ErrorPolicyFailFast
+	case "retry":
+		return extractor.ErrorPolicyRetry
+	case "dead-letter":
+		return extractor.ErrorPolicyDeadLetter
+	}
+	return extractor.ErrorPolicyReport
+}
+
+// loadCredentials resolves the credentials from the credentials file, secret file and flags, in increasing
+// order of precedence
+func loadCredentials() *extractor.Credentials {
+	creds := &extractor.Credentials{}
+	if *credentialsFile != "" {
+		c, err := extractor.LoadCredentialsFile(*credentialsFile)
+		check(err)
+		creds = c
+	}
+	if *secretFile != "" {
+		if *secret != "" {
+			panic("Only one of --secret and --secret-file may be set")
+		}
+		s, err := extractor.ReadSecret(*secretFile, os.Stdin)
+		check(err)
+		creds.Secret = s
+	}
+	if *key != "" {
+		creds.Key = *key
+	}
+	if *secret != "" {
+		creds.Secret = *secret
+	}
+	if *passphrase != "" {
+		creds.Passphrase = *passphrase
+	}
+	return creds
+}
+
+func printVars(creds *extractor.Credentials) {
 	fmt.Printf("Now                     : %s\n", now.Format(timeFmt))
 	fmt.Printf("Product ID              : %s\n", *product)
-	fmt.Printf("Secret                  : %s\n", *secret)
-	fmt.Printf("Key                     : %s\n", *key)
-	fmt.Printf("Passphrase              : %s\n", *passphrase)
+	fmt.Printf("Secret                  : %s\n", extractor.Redact(creds.Secret))
+	fmt.Printf("Key                     : %s\n", extractor.Redact(creds.Key))
+	fmt.Printf("Passphrase              : %s\n", extractor.Redact(creds.Passphrase))
+	if *credentialsFile != "" {
+		fmt.Printf("Credentials File        : %s\n", *credentialsFile)
+	}
+	if *secretFile != "" {
+		fmt.Printf("Secret File             : %s\n", *secretFile)
+	}
+	if *baseURL != "" {
+		fmt.Printf("Base URL                : %s\n", *baseURL)
+	}
 	fmt.Printf("Granularity             : %d\n", *granularity)
-	fmt.Printf("Start                   : %s\n", *start)
-	fmt.Printf("End                     : %s\n", *end)
+	fmt.Printf("Aggregate               : %t\n", *aggregate)
+	fmt.Printf("Order                   : %s\n", *order)
+	if *resampleTo > 0 {
+		fmt.Printf("Resample To             : %d\n", *resampleTo)
+	}
+	fmt.Printf("Drop Zero Volume        : %t\n", *dropZeroVolume)
+	if *round >= 0 {
+		fmt.Printf("Round                   : %d\n", *round)
+	}
+	if *keepFields != "" {
+		fmt.Printf("Keep Fields             : %s\n", *keepFields)
+	}
+	if *indicatorSpec != "" {
+		fmt.Printf("Indicators              : %s\n", *indicatorSpec)
+	}
+	fmt.Printf("Detect Gaps             : %t\n", *detectGaps || *refetchGaps)
+	fmt.Printf("Refetch Gaps            : %t\n", *refetchGaps)
+	fmt.Printf("Checkpoint              : %t\n", *checkpoint || *resume)
+	if *checkpoint || *resume {
+		fmt.Printf("Checkpoint File         : %s\n", *checkpointFile)
+		fmt.Printf("Resume                  : %t\n", *resume)
+	}
+	if *replayFiles != "" {
+		fmt.Printf("Replay                  : %s\n", *replayFiles)
+	}
+	s, e := timeRange(*replayFiles != "")
+	fmt.Printf("Start                   : %s\n", formatTime(s))
+	fmt.Printf("End                     : %s\n", formatTime(e))
+	fmt.Printf("Rate Limit              : %g/s (burst %d)\n", *rateLimit, *rateBurst)
+	fmt.Printf("Receiver Buffer         : %d (%s)\n", *receiverBuffer, *backpressure)
+	if *backpressure == "spill" && *spillDir != "" {
+		fmt.Printf("Spill Dir               : %s\n", *spillDir)
+	}
+	fmt.Printf("Batch                   : %d (max %s)\n", *batchSize, batchLatency.String())
+	fmt.Printf("On Error                : %s\n", *onError)
+	if *onError == "dead-letter" || *onError == "retry" {
+		fmt.Printf("Dead Letter File        : %s\n", *deadLetterFile)
+	}
+	fmt.Printf("Retry Attempts          : %d\n", *retryAttempts)
+	fmt.Printf("Retry Max Delay         : %s\n", retryMaxDelay.String())
 
 	fmt.Printf("Out stdout              : %t\n", *outStd)
 
@@ -197,11 +750,81 @@ func printVars() {
 		fmt.Printf("Out JSON File           : %s\n", *outJSONFile)
 	}
 
+	fmt.Printf("Out Parquet             : %t\n", *outParquet)
+	if *outParquet {
+		fmt.Printf("Out Parquet File        : %s\n", *outParquetFile)
+		fmt.Printf("Out Parquet Row Group   : %d\n", *outParquetRowGroup)
+		fmt.Printf("Out Parquet Compression : %s\n", *outParquetCompression)
+	}
+
+	fmt.Printf("Out SQLite              : %t\n", *outSQLite)
+	if *outSQLite {
+		fmt.Printf("Out SQLite File         : %s\n", *outSQLiteFile)
+		fmt.Printf("Out SQLite Table        : %s\n", *outSQLiteTable)
+		fmt.Printf("Out SQLite Batch        : %d\n", *outSQLiteBatch)
+	}
+
+	fmt.Printf("Out Postgres            : %t\n", *outPG)
+	if *outPG {
+		dsn := *outPGDSN
+		if pass := receivers.DSNPassword(dsn); pass != "" {
+			dsn = strings.Replace(dsn, pass, extractor.Redacted, -1)
+		}
+		fmt.Printf("Out Postgres DSN        : %s\n", dsn)
+		fmt.Printf("Out Postgres Table      : %s\n", *outPGTable)
+		fmt.Printf("Out Postgres Hypertable : %t\n", *outPGHypertable)
+		fmt.Printf("Out Postgres COPY       : %t\n", *outPGCopy)
+		fmt.Printf("Out Postgres Batch      : %d\n", *outPGBatch)
+	}
+
+	fmt.Printf("Out Line Protocol       : %t\n", *outLP)
+	if *outLP {
+		fmt.Printf("Out Line Protocol File  : %s\n", *outLPFile)
+	}
+
+	fmt.Printf("Out InfluxDB            : %t\n", *outInflux)
+	if *outInflux {
+		fmt.Printf("Out InfluxDB URL        : %s (v%s)\n", *outInfluxURL, *outInfluxVersion)
+		if *outInfluxVersion == "1" {
+			fmt.Printf("Out InfluxDB Database   : %s\n", *outInfluxDB)
+		} else {
+			fmt.Printf("Out InfluxDB Bucket     : %s/%s\n", *outInfluxOrg, *outInfluxBucket)
+		}
+		if *outInfluxToken != "" {
+			fmt.Printf("Out InfluxDB Token      : %s\n", extractor.Redact(*outInfluxToken))
+		}
+		if *outInfluxUser != "" || *outInfluxPassword != "" {
+			fmt.Printf("Out InfluxDB User       : %s (password %s)\n", *outInfluxUser, extractor.Redact(*outInfluxPassword))
+		}
+		fmt.Printf("Out InfluxDB Gzip       : %t\n", *outInfluxGzip)
+	}
+	if *outLP || *outInflux {
+		fmt.Printf("Out InfluxDB Measurement: %s\n", *outInfluxMeasurement)
+	}
+
 	fmt.Printf("Out Elasticsearch       : %t\n", *outES)
 	if *outES {
 		fmt.Printf("Out Elasticsearch Index : %s\n", *outESIdx)
 		fmt.Printf("Out Elasticsearch Host  : %s\n", *outESHost)
 		fmt.Printf("Out Elasticsearch Port  : %s\n", *outESPort)
+		if *outESUser != "" || *outESPassword != "" {
+			fmt.Printf("Out Elasticsearch User  : %s (password %s)\n", *outESUser, extractor.Redact(*outESPassword))
+		}
+		if *outESAPIKey != "" {
+			fmt.Printf("Out Elasticsearch Key   : %s\n", extractor.Redact(*outESAPIKey))
+		}
+		if *outESCACert != "" {
+			fmt.Printf("Out Elasticsearch CA    : %s\n", *outESCACert)
+		}
+		fmt.Printf("Out Elasticsearch Types : %t\n", *outESTypes)
 	}
 
 }
+
+func printStats(stats []extractor.ReceiverStats) {
+	fmt.Print("\nReceivers:\n")
+	for _, s := range stats {
+		fmt.Printf("%-24T: %d delivered, %d dropped, %d failed, %d spilled, %d retried, %d dead lettered\n",
+			s.Receiver, s.Delivered, s.Dropped, s.Failed, s.Spilled, s.Retried, s.DeadLettered)
+	}
+}
@@ -11,17 +11,30 @@ import (
 	"github.com/johnhof/gdax-candle-extractor/extractor"
 )
 
+// csvHeader is the title row of the CSV file. product and source follow the original columns, so
+// files written before they were added remain readable by position
+var csvHeader = []string{"Time", "Granularity", "Low", "High", "Open", "Close", "Volume", "Product", "Source"}
+
 // CSVRcv implements Receiver to allow it to be used in a collector
 type CSVRcv struct {
 	Path    string
 	Pointer *os.File
 	Writer  *csv.Writer
 	Mutex   *sync.Mutex
+	// Fields are the computed candlestick fields written as columns after the default ones
+	Fields []string
+}
+
+// NewCSV build a csv Receiver, cretating a blank file. existing files will be overwritten, unless
+// the append option is set
+func NewCSV(path string, appendOpt ...bool) (*CSVRcv, error) {
+	return NewCSVWithFields(path, nil, appendOpt...)
 }
 
-// NewCSV build a csv Receiver, cretating a blank file. existing files will be overwritten
-func NewCSV(path string) (*CSVRcv, error) {
-	ptr, err := os.Create(path)
+// NewCSVWithFields builds a csv Receiver which writes the computed fields, such as indicators, as
+// additional columns. candlesticks without a value for a field leave its column empty
+func NewCSVWithFields(path string, fields []string, appendOpt ...bool) (*CSVRcv, error) {
+	ptr, size, err := openFile(path, appendOpt)
 	if err != nil {
 		return &CSVRcv{}, err
 	}
@@ -33,11 +46,16 @@ func NewCSV(path string) (*CSVRcv, error) {
 		Pointer: ptr,
 		Writer:  wtr,
 		Mutex:   &sync.Mutex{},
+		Fields:  fields,
+	}
+
+	// the header is already present when appending
+	if size > 0 {
+		return rcv, nil
 	}
 
 	defer rcv.Writer.Flush()
-	title := []string{"Time", "Granularity", "Low", "High", "Open", "Close", "Volume"}
-	err = rcv.Writer.Write(title)
+	err = rcv.Writer.Write(append(append([]string{}, csvHeader...), fields...))
 	return rcv, err
 }
 
@@ -46,26 +64,51 @@ func (r *CSVRcv) Collect(c *extractor.Candlestick) error {
 	r.Mutex.Lock()
 	defer r.Mutex.Unlock()
 	defer r.Writer.Flush()
-	t := string(c.Datetime)
-	g := strconv.Itoa(c.Granularity)
-	l := fToS(c.Low)
-	h := fToS(c.High)
-	o := fToS(c.Open)
-	cl := fToS(c.Close)
-	v := fToS(c.Volume)
-	row := []string{t, g, l, h, o, cl, v}
-	err := r.Writer.Write(row)
+	err := r.Writer.Write(csvRow(c, r.Fields))
 	if err != nil {
 		fmt.Println(err)
 	}
 	return err
 }
 
+// CollectBatch writes the candlesticks to the output file, flushing once for the whole batch
+func (r *CSVRcv) CollectBatch(cdls []*extractor.Candlestick) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	for _, c := range cdls {
+		if err := r.Writer.Write(csvRow(c, r.Fields)); err != nil {
+			return err
+		}
+	}
+	r.Writer.Flush()
+	return r.Writer.Error()
+}
+
 // Close closes the file pointer
 func (r *CSVRcv) Close() {
 	r.Pointer.Close()
 }
 
+func csvRow(c *extractor.Candlestick, fields []string) []string {
+	t := string(c.Datetime)
+	g := strconv.Itoa(c.Granularity)
+	l := fToS(c.Low)
+	h := fToS(c.High)
+	o := fToS(c.Open)
+	cl := fToS(c.Close)
+	v := fToS(c.Volume)
+	row := []string{t, g, l, h, o, cl, v, c.Product, c.Source}
+	for _, f := range fields {
+		val, ok := c.Fields[f]
+		if !ok {
+			row = append(row, "")
+			continue
+		}
+		row = append(row, strconv.FormatFloat(val, 'f', -1, 64))
+	}
+	return row
+}
+
 func fToS(f float64) string {
 	return strings.Trim(fmt.Sprintf("%f", f), "0")
 }
@@ -2,24 +2,65 @@ package receivers
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/johnhof/gdax-candle-extractor/extractor"
 )
 
+// DefaultESPriceScalingFactor stores prices to 8 decimal places, the precision of most exchanges
+const DefaultESPriceScalingFactor = 100000000
+
 // ESRcv implements Receiver to allow it ot be used in a collector
 type ESRcv struct {
-	Secure  bool
-	Index   string
-	Host    string
-	Port    string
-	BaseURL string
-	Mutex   *sync.Mutex
-	Client  *http.Client
+	Secure   bool
+	Index    string
+	Host     string
+	Port     string
+	BaseURL  string
+	Username string
+	Password string
+	APIKey   string
+	// Types indexes each granularity as its own mapping type, for clusters older than Elasticsearch 7.
+	// see ESConfig.Types for the versions supported
+	Types  bool
+	Mutex  *sync.Mutex
+	Client *http.Client
+}
+
+// ESConfig provides values for the Elasticsearch receiver
+type ESConfig struct {
+	Index  string
+	Host   string
+	Port   string
+	Secure bool
+	// Username and Password authenticate with basic auth, if set
+	Username string
+	Password string
+	// APIKey authenticates with the base64 encoded `id:api_key`, if set
+	APIKey string
+	// CACert is the path of a PEM encoded certificate authority to verify the cluster with, in addition to the system's
+	CACert string
+	// Types indexes each granularity as its own mapping type, for Elasticsearch 5.x. 6.x is supported if
+	// the index only holds a single granularity, as it allows one mapping type per index. 7 and later reject
+	// mapping types, and the _default_ mapping of the legacy template installed with them
+	Types bool
+	// Template installs an index template for the index, mapping timestamp as a date and prices as scaled floats.
+	// a composable template is installed, which needs Elasticsearch 7.8 or later, or a legacy template with Types
+	Template bool
+	// PriceScalingFactor of the scaled float price fields in the template. defaults to DefaultESPriceScalingFactor
+	PriceScalingFactor float64
+	// Client is used for every request. a client with a 30 second timeout is used if nil. with CACert, a copy
+	// of the client is used instead, with the CA set on a clone of its transport, which must be an *http.Transport
+	Client *http.Client
 }
 
 // ESDocBody wraps the candlestick in an accepted json format for the upsert operation
@@ -28,62 +69,245 @@ type ESDocBody struct {
 	AsUpsert bool                   `json:"doc_as_upsert"`
 }
 
-// NewElasticsearch build a json Receiver, cretating a blank file. existing files will be overwritten
+// NewElasticsearch build an Elasticsearch Receiver, indexing to the index on the host and port
 func NewElasticsearch(index string, host string, port string, secOpt ...bool) (*ESRcv, error) {
-	// prt, err := os.OpenFile(filename, os.O_APPEND, 0666)
+	return NewElasticsearchWithConfig(&ESConfig{
+		Index:  index,
+		Host:   host,
+		Port:   port,
+		Secure: len(secOpt) > 0 && secOpt[0],
+	})
+}
+
+// NewElasticsearchWithConfig builds an Elasticsearch Receiver from the config, installing the index template if set
+func NewElasticsearchWithConfig(config *ESConfig) (*ESRcv, error) {
 	protocol := "http"
-	secure := false
-	if len(secOpt) > 0 && secOpt[0] == true {
-		secure = true
+	if config.Secure {
 		protocol = "https"
 	}
 
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if config.CACert != "" {
+		pem, err := ioutil.ReadFile(config.CACert)
+		if err != nil {
+			return &ESRcv{}, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return &ESRcv{}, fmt.Errorf("No certificates found in CA cert [%s]", config.CACert)
+		}
+		// trust the CA on a copy of the client's transport, keeping the rest of its config
+		rt := client.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+		transport, ok := rt.(*http.Transport)
+		if !ok {
+			return &ESRcv{}, fmt.Errorf("CA cert [%s] can't be set on a client transport of type %T", config.CACert, rt)
+		}
+		transport = transport.Clone()
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+		withCA := *client
+		withCA.Transport = transport
+		client = &withCA
+	}
+
 	rcv := &ESRcv{
-		Secure:  secure,
-		Index:   index,
-		Host:    host,
-		Port:    port,
-		BaseURL: fmt.Sprintf("%s://%s:%s/%s", protocol, host, port, index),
-		Mutex:   &sync.Mutex{},
-		Client:  &http.Client{},
+		Secure:   config.Secure,
+		Index:    config.Index,
+		Host:     config.Host,
+		Port:     config.Port,
+		BaseURL:  fmt.Sprintf("%s://%s:%s/%s", protocol, config.Host, config.Port, config.Index),
+		Username: config.Username,
+		Password: config.Password,
+		APIKey:   config.APIKey,
+		Types:    config.Types,
+		Mutex:    &sync.Mutex{},
+		Client:   client,
+	}
+
+	if config.Template {
+		scaling := config.PriceScalingFactor
+		if scaling <= 0 {
+			scaling = DefaultESPriceScalingFactor
+		}
+		if err := rcv.installTemplate(fmt.Sprintf("%s://%s:%s", protocol, config.Host, config.Port), scaling); err != nil {
+			return rcv, err
+		}
 	}
 	return rcv, nil
 }
 
-// Collect upserts the candlestick into the set index. the candlestick
-// granularity in seconds is the type, and the time string is the ID, used to
-// prevent double-indexing existing executions
+// ESDocID returns the ID of the candlestick's document. it is built from the product, granularity and
+// timestamp, so re-indexing a candlestick updates the existing document rather than duplicating it
+func ESDocID(c *extractor.Candlestick) string {
+	return fmt.Sprintf("%s-%d-%d", c.Product, c.Granularity, c.Timestamp)
+}
+
+// Collect upserts the candlestick into the set index
 func (r *ESRcv) Collect(c *extractor.Candlestick) error {
+	return r.CollectBatch([]*extractor.Candlestick{c})
+}
+
+// esBulkAction is the action line of a bulk request
+type esBulkAction struct {
+	Update esBulkMeta `json:"update"`
+}
+
+type esBulkMeta struct {
+	Type string `json:"_type,omitempty"`
+	ID   string `json:"_id"`
+}
+
+// esBulkResponse is the part of the bulk response needed to find failed items
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// CollectBatch upserts the candlesticks into the set index with a single bulk request
+func (r *ESRcv) CollectBatch(cdls []*extractor.Candlestick) error {
 	r.Mutex.Lock()
 	defer r.Mutex.Unlock()
-	b, err := json.Marshal(ESDocBody{c, true})
-	if err != nil {
-		return err
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, c := range cdls {
+		meta := esBulkMeta{ID: ESDocID(c)}
+		if r.Types {
+			meta.Type = strconv.Itoa(c.Granularity)
+		}
+		if err := enc.Encode(esBulkAction{meta}); err != nil {
+			return err
+		}
+		if err := enc.Encode(ESDocBody{c, true}); err != nil {
+			return err
+		}
 	}
 
-	// add the type and ID to the upsert request
-	URL := fmt.Sprintf("%s/%d/%s/_update", r.BaseURL, c.Granularity, c.Datetime)
-	req, err := http.NewRequest("POST", URL, bytes.NewBuffer(b))
+	bts, err := r.do("POST", r.BaseURL+"/_bulk", "application/x-ndjson", &buf)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	res, err := r.Client.Do(req)
-	if err != nil {
+	// the request succeeds as a whole even if individual documents fail
+	bulk := esBulkResponse{}
+	if err = json.Unmarshal(bts, &bulk); err != nil {
 		return err
 	}
-	if res.StatusCode > 299 {
-		bts, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return err
+	if !bulk.Errors {
+		return nil
+	}
+	failed := 0
+	var first string
+	for _, item := range bulk.Items {
+		for _, res := range item {
+			if res.Status > 299 {
+				if failed == 0 {
+					first = fmt.Sprintf("[%d] %s: %s", res.Status, res.ID, string(res.Error))
+				}
+				failed++
+			}
 		}
-		return fmt.Errorf("ERR: [%d] %s", res.StatusCode, string(bts))
 	}
-	return err
+	return fmt.Errorf("ERR: %d of %d documents failed, first: %s", failed, len(cdls), first)
 }
 
 // Close acts as a no-op to implement the receiver interface
 func (r *ESRcv) Close() {
 	// NOOP
 }
+
+// installTemplate puts an index template for the index. composable templates are used for typeless
+// indices, and legacy templates for typed ones
+func (r *ESRcv) installTemplate(hostURL string, priceScaling float64) error {
+	price := map[string]interface{}{"type": "scaled_float", "scaling_factor": priceScaling}
+	properties := map[string]interface{}{
+		"product":     map[string]string{"type": "keyword"},
+		"source":      map[string]string{"type": "keyword"},
+		"datetime":    map[string]string{"type": "keyword"},
+		"granularity": map[string]string{"type": "integer"},
+		"timestamp":   map[string]string{"type": "date", "format": "epoch_second"},
+		"low":         price,
+		"high":        price,
+		"open":        price,
+		"close":       price,
+		"volume":      map[string]string{"type": "double"},
+	}
+	// computed fields are mapped as doubles, rather than guessed from their first value, which may be whole
+	dynamic := []map[string]interface{}{
+		{"fields": map[string]interface{}{"path_match": "fields.*", "mapping": map[string]string{"type": "double"}}},
+	}
+	mappings := map[string]interface{}{"properties": properties, "dynamic_templates": dynamic}
+	patterns := []string{r.Index, r.Index + "-*"}
+
+	var template interface{}
+	URL := fmt.Sprintf("%s/_index_template/%s", hostURL, r.Index)
+	if r.Types {
+		// legacy templates apply the mapping to every type with the _default_ mapping. the pattern is set with
+		// template rather than index_patterns, which Elasticsearch 5 doesn't accept
+		URL = fmt.Sprintf("%s/_template/%s", hostURL, r.Index)
+		template = map[string]interface{}{
+			"template": r.Index + "*",
+			"mappings": map[string]interface{}{
+				"_default_": mappings,
+			},
+		}
+	} else {
+		template = map[string]interface{}{
+			"index_patterns": patterns,
+			"template": map[string]interface{}{
+				"mappings": mappings,
+			},
+		}
+	}
+
+	b, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("PUT", URL, "application/json", bytes.NewReader(b))
+	return err
+}
+
+// do makes an authenticated request to the cluster, returning the response body
+func (r *ESRcv) do(method string, URL string, contentType string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, URL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	if r.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+r.APIKey)
+	} else if r.Username != "" || r.Password != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	res, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	bts, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode > 299 {
+		return bts, fmt.Errorf("ERR: [%d] %s", res.StatusCode, string(bts))
+	}
+	return bts, nil
+}
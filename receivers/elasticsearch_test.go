@@ -0,0 +1,61 @@
+package receivers_test
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/receivers"
+)
+
+func TestElasticsearchCACertKeepsTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors":false}`))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "elasticsearch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caCert := filepath.Join(dir, "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err = ioutil.WriteFile(caCert, pemBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &http.Transport{MaxIdleConnsPerHost: 7}
+	client := &http.Client{Timeout: 5 * time.Second, Transport: transport}
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	rcv, err := receivers.NewElasticsearchWithConfig(&receivers.ESConfig{
+		Index:  "candles",
+		Host:   host,
+		Port:   port,
+		Secure: true,
+		CACert: caCert,
+		Client: client,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the CA is set on a clone of the caller's transport, leaving theirs untouched
+	got, ok := rcv.Client.Transport.(*http.Transport)
+	if !ok || got == transport || got.MaxIdleConnsPerHost != 7 || got.TLSClientConfig.RootCAs == nil {
+		t.Fatalf("expected a clone of the client's transport with the CA, got %+v", rcv.Client.Transport)
+	}
+	if (transport.TLSClientConfig != nil && transport.TLSClientConfig.RootCAs != nil) || client.Transport != transport || rcv.Client.Timeout != client.Timeout {
+		t.Error("expected the caller's client to be left unchanged")
+	}
+	if err = rcv.CollectBatch([]*extractor.Candlestick{{Product: "BTC-USD", Granularity: 60, Timestamp: 1500000000}}); err != nil {
+		t.Errorf("expected the cluster to be verified with the CA, got %s", err)
+	}
+}
@@ -0,0 +1,47 @@
+package receivers
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// openFile creates the file, or opens it for appending if the append option is set. the returned
+// size is the length of the existing content
+func openFile(path string, appendOpt []bool) (*os.File, int64, error) {
+	if len(appendOpt) == 0 || !appendOpt[0] {
+		ptr, err := os.Create(path)
+		return ptr, 0, err
+	}
+
+	ptr, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return ptr, 0, err
+	}
+	size, err := ptr.Seek(0, io.SeekEnd)
+	return ptr, size, err
+}
+
+// trimSuffix removes the trailing closing bytes (ignoring whitespace) from the end of the file, leaving the
+// pointer at the new end. used to reopen files which were terminated on close
+func trimSuffix(ptr *os.File, size int64, suffix []byte) (int64, error) {
+	tail := int64(64)
+	if tail > size {
+		tail = size
+	}
+	buf := make([]byte, tail)
+	if _, err := ptr.ReadAt(buf, size-tail); err != nil {
+		return size, err
+	}
+
+	trimmed := bytes.TrimRight(buf, " \t\r\n")
+	if !bytes.HasSuffix(trimmed, suffix) {
+		return size, nil
+	}
+	size = size - tail + int64(len(trimmed)-len(suffix))
+	if err := ptr.Truncate(size); err != nil {
+		return size, err
+	}
+	_, err := ptr.Seek(size, io.SeekStart)
+	return size, err
+}
@@ -0,0 +1,274 @@
+package receivers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+)
+
+// DefaultInfluxMeasurement is the measurement candlesticks are written to if none is configured
+const DefaultInfluxMeasurement = "candles"
+
+var (
+	// influxMeasurementEscaper escapes the special characters of a measurement name
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	// influxKeyEscaper escapes the special characters of tag keys and values, and field keys
+	influxKeyEscaper = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+)
+
+// InfluxLine serializes the candlestick to InfluxDB line protocol, with the product, granularity and source as
+// tags, the prices, volume and computed fields as float fields, and the timestamp in nanoseconds
+func InfluxLine(measurement string, c *extractor.Candlestick) string {
+	var b bytes.Buffer
+	b.WriteString(influxMeasurementEscaper.Replace(measurement))
+
+	// tags are written sorted by key, as recommended for write performance
+	b.WriteString(",granularity=" + strconv.Itoa(c.Granularity))
+	if c.Product != "" {
+		b.WriteString(",product=" + influxKeyEscaper.Replace(c.Product))
+	}
+	if c.Source != "" {
+		b.WriteString(",source=" + influxKeyEscaper.Replace(c.Source))
+	}
+
+	b.WriteString(" open=" + influxFloat(c.Open))
+	b.WriteString(",high=" + influxFloat(c.High))
+	b.WriteString(",low=" + influxFloat(c.Low))
+	b.WriteString(",close=" + influxFloat(c.Close))
+	b.WriteString(",volume=" + influxFloat(c.Volume))
+	keys := make([]string, 0, len(c.Fields))
+	for k := range c.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// line protocol has no representation of NaN or infinity
+		if v := c.Fields[k]; !math.IsNaN(v) && !math.IsInf(v, 0) {
+			b.WriteString("," + influxKeyEscaper.Replace(k) + "=" + influxFloat(v))
+		}
+	}
+
+	b.WriteString(" " + strconv.FormatInt(c.Timestamp*int64(time.Second), 10))
+	return b.String()
+}
+
+func influxFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// influxLines serializes the candlesticks as newline terminated lines
+func influxLines(measurement string, cdls []*extractor.Candlestick) []byte {
+	var b bytes.Buffer
+	for _, c := range cdls {
+		b.WriteString(InfluxLine(measurement, c))
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// LineProtocolRcv implements Receiver to allow it to be used in a collector, writing InfluxDB line
+// protocol to a file which can be loaded with `influx write` or the HTTP API
+type LineProtocolRcv struct {
+	Path        string
+	Measurement string
+	Pointer     *os.File
+	Mutex       *sync.Mutex
+}
+
+// NewLineProtocol build a line protocol Receiver, creating a blank file. existing files will be overwritten,
+// unless the append option is set. the measurement defaults to DefaultInfluxMeasurement if empty
+func NewLineProtocol(path string, measurement string, appendOpt ...bool) (*LineProtocolRcv, error) {
+	ptr, _, err := openFile(path, appendOpt)
+	if err != nil {
+		return &LineProtocolRcv{}, err
+	}
+	if measurement == "" {
+		measurement = DefaultInfluxMeasurement
+	}
+	return &LineProtocolRcv{
+		Path:        path,
+		Measurement: measurement,
+		Pointer:     ptr,
+		Mutex:       &sync.Mutex{},
+	}, nil
+}
+
+// Collect writes the candlestick to the output file
+func (r *LineProtocolRcv) Collect(c *extractor.Candlestick) error {
+	return r.CollectBatch([]*extractor.Candlestick{c})
+}
+
+// CollectBatch writes the candlesticks to the output file in a single write
+func (r *LineProtocolRcv) CollectBatch(cdls []*extractor.Candlestick) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	_, err := r.Pointer.Write(influxLines(r.Measurement, cdls))
+	return err
+}
+
+// Close closes the file pointer
+func (r *LineProtocolRcv) Close() {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	r.Pointer.Close()
+}
+
+// InfluxConfig provides values for the InfluxDB receiver
+type InfluxConfig struct {
+	// URL of the InfluxDB server, e.g. http://localhost:8086
+	URL string
+	// Version of the write API, 1 or 2. defaults to 2
+	Version int
+	// Database and RetentionPolicy are written to with the v1 API. the default retention policy is used if empty
+	Database        string
+	RetentionPolicy string
+	// Org and Bucket are written to with the v2 API
+	Org    string
+	Bucket string
+	// Token authenticates with the `Authorization: Token` header, if set. it's used by the v2 API, and the v1
+	// compatibility API of InfluxDB 2 and later
+	Token string
+	// Username and Password authenticate with basic auth, if set. used by the v1 API of InfluxDB 1
+	Username string
+	Password string
+	// Measurement the candlesticks are written to. defaults to DefaultInfluxMeasurement
+	Measurement string
+	// Gzip compresses each request body
+	Gzip bool
+	// Client is used for every request. a client with a 30 second timeout is used if nil
+	Client *http.Client
+}
+
+// InfluxRcv implements Receiver to allow it to be used in a collector, POSTing batches of line protocol to
+// the write endpoint of an InfluxDB server
+type InfluxRcv struct {
+	WriteURL    string
+	Measurement string
+	Token       string
+	Username    string
+	Password    string
+	Gzip        bool
+	Mutex       *sync.Mutex
+	Client      *http.Client
+}
+
+// NewInflux builds an InfluxDB Receiver, writing to the bucket of the org with the v2 API
+func NewInflux(URL string, org string, bucket string, token string) (*InfluxRcv, error) {
+	return NewInfluxWithConfig(&InfluxConfig{URL: URL, Org: org, Bucket: bucket, Token: token})
+}
+
+// NewInfluxWithConfig builds an InfluxDB Receiver from the config
+func NewInfluxWithConfig(config *InfluxConfig) (*InfluxRcv, error) {
+	base, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return &InfluxRcv{}, fmt.Errorf("Invalid InfluxDB URL [%s]", config.URL)
+	}
+
+	query := url.Values{"precision": {"ns"}}
+	switch config.Version {
+	case 1:
+		if config.Database == "" {
+			return &InfluxRcv{}, fmt.Errorf("An InfluxDB database is required for the v1 API")
+		}
+		base.Path += "/write"
+		query.Set("db", config.Database)
+		if config.RetentionPolicy != "" {
+			query.Set("rp", config.RetentionPolicy)
+		}
+	case 0, 2:
+		if config.Org == "" || config.Bucket == "" {
+			return &InfluxRcv{}, fmt.Errorf("An InfluxDB org and bucket are required for the v2 API")
+		}
+		base.Path += "/api/v2/write"
+		query.Set("org", config.Org)
+		query.Set("bucket", config.Bucket)
+	default:
+		return &InfluxRcv{}, fmt.Errorf("Unsupported InfluxDB API version [%d], expected 1 or 2", config.Version)
+	}
+	base.RawQuery = query.Encode()
+
+	measurement := config.Measurement
+	if measurement == "" {
+		measurement = DefaultInfluxMeasurement
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &InfluxRcv{
+		WriteURL:    base.String(),
+		Measurement: measurement,
+		Token:       config.Token,
+		Username:    config.Username,
+		Password:    config.Password,
+		Gzip:        config.Gzip,
+		Mutex:       &sync.Mutex{},
+		Client:      client,
+	}, nil
+}
+
+// Collect writes the candlestick to InfluxDB
+func (r *InfluxRcv) Collect(c *extractor.Candlestick) error {
+	return r.CollectBatch([]*extractor.Candlestick{c})
+}
+
+// CollectBatch writes the candlesticks to InfluxDB with a single request
+func (r *InfluxRcv) CollectBatch(cdls []*extractor.Candlestick) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	body := influxLines(r.Measurement, cdls)
+	if r.Gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(body); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequest("POST", r.WriteURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Token "+r.Token)
+	} else if r.Username != "" || r.Password != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	res, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		bts, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("ERR: [%d] %s", res.StatusCode, string(bts))
+	}
+	return nil
+}
+
+// Close acts as a no-op to implement the receiver interface
+func (r *InfluxRcv) Close() {
+	// NOOP
+}
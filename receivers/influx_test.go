@@ -0,0 +1,191 @@
+package receivers_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/receivers"
+)
+
+func TestInfluxLine(t *testing.T) {
+	cases := []struct {
+		name        string
+		measurement string
+		cdl         extractor.Candlestick
+		want        string
+	}{
+		{
+			"plain", "candles",
+			extractor.Candlestick{Product: "BTC-USD", Source: "gdax", Granularity: 3600, Timestamp: 1509494400,
+				Open: 7050.01, High: 7089.99, Low: 7032.5, Close: 7066.7, Volume: 412.31},
+			"candles,granularity=3600,product=BTC-USD,source=gdax open=7050.01,high=7089.99,low=7032.5,close=7066.7,volume=412.31 1509494400000000000",
+		},
+		{
+			"escaped", "btc candles,1h",
+			extractor.Candlestick{Product: "BTC USD,a=b", Source: "my exchange", Granularity: 60, Timestamp: 1,
+				Fields: map[string]float64{"sma 3": 1.5, "a=b,c": 2}},
+			`btc\ candles\,1h,granularity=60,product=BTC\ USD\,a\=b,source=my\ exchange open=0,high=0,low=0,close=0,volume=0,a\=b\,c=2,sma\ 3=1.5 1000000000`,
+		},
+		{
+			// the fields are sorted, and those line protocol can't represent are left out
+			"fields", "candles",
+			extractor.Candlestick{Granularity: 60, Timestamp: 60, Fields: map[string]float64{
+				"sma_3": 2, "ema_3": 1e-7, "rsi_14": math.NaN(), "bb_upper_20_2": math.Inf(1), "atr_14": math.Inf(-1),
+			}},
+			"candles,granularity=60 open=0,high=0,low=0,close=0,volume=0,ema_3=0.0000001,sma_3=2 60000000000",
+		},
+	}
+	for _, tc := range cases {
+		if got := receivers.InfluxLine(tc.measurement, &tc.cdl); got != tc.want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tc.name, tc.want, got)
+		}
+	}
+}
+
+// influxRequest is what the mock InfluxDB server received
+type influxRequest struct {
+	path     string
+	query    url.Values
+	auth     string
+	encoding string
+	body     string
+}
+
+// influxServer records each write request, decompressing gzip bodies, and responds with the status
+func influxServer(t *testing.T, status int, reqs *[]influxRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		var err error
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			var gz *gzip.Reader
+			if gz, err = gzip.NewReader(r.Body); err == nil {
+				body, err = ioutil.ReadAll(gz)
+			}
+		} else {
+			body, err = ioutil.ReadAll(r.Body)
+		}
+		if err != nil {
+			t.Errorf("failed to read the request body: %s", err)
+		}
+		*reqs = append(*reqs, influxRequest{
+			path:     r.URL.Path,
+			query:    r.URL.Query(),
+			auth:     r.Header.Get("Authorization"),
+			encoding: r.Header.Get("Content-Encoding"),
+			body:     string(body),
+		})
+		w.WriteHeader(status)
+		if status > 299 {
+			w.Write([]byte(`{"code":"invalid","message":"bad line"}`))
+		}
+	}))
+}
+
+func TestInfluxWrite(t *testing.T) {
+	cdls := []*extractor.Candlestick{
+		{Product: "BTC-USD", Source: "gdax", Granularity: 60, Timestamp: 1500000000, Close: 100},
+		{Product: "BTC-USD", Source: "gdax", Granularity: 60, Timestamp: 1500000060, Close: 101},
+	}
+	lines := receivers.InfluxLine("candles", cdls[0]) + "\n" + receivers.InfluxLine("candles", cdls[1]) + "\n"
+
+	cases := []struct {
+		name   string
+		config receivers.InfluxConfig
+		path   string
+		query  url.Values
+		auth   string
+	}{
+		{
+			"v2 token", receivers.InfluxConfig{Org: "my org", Bucket: "candles/1m", Token: "secret-token"},
+			"/api/v2/write", url.Values{"org": {"my org"}, "bucket": {"candles/1m"}, "precision": {"ns"}}, "Token secret-token",
+		},
+		{
+			"v2 gzip", receivers.InfluxConfig{Version: 2, Org: "org", Bucket: "bucket", Token: "t", Gzip: true},
+			"/api/v2/write", url.Values{"org": {"org"}, "bucket": {"bucket"}, "precision": {"ns"}}, "Token t",
+		},
+		{
+			"v1 basic auth", receivers.InfluxConfig{Version: 1, Database: "market", RetentionPolicy: "a year", Username: "user", Password: "pass"},
+			"/write", url.Values{"db": {"market"}, "rp": {"a year"}, "precision": {"ns"}}, "Basic dXNlcjpwYXNz",
+		},
+		{
+			// InfluxDB 2's v1 compatibility API authenticates with a token
+			"v1 token", receivers.InfluxConfig{Version: 1, Database: "market", Token: "t", Username: "ignored", Gzip: true},
+			"/write", url.Values{"db": {"market"}, "precision": {"ns"}}, "Token t",
+		},
+		{
+			"v1 anonymous", receivers.InfluxConfig{Version: 1, Database: "market"},
+			"/write", url.Values{"db": {"market"}, "precision": {"ns"}}, "",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var reqs []influxRequest
+			srv := influxServer(t, http.StatusNoContent, &reqs)
+			defer srv.Close()
+
+			config := tc.config
+			// a trailing slash on the server URL is ignored
+			config.URL = srv.URL + "/"
+			rcv, err := receivers.NewInfluxWithConfig(&config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = rcv.CollectBatch(cdls); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(reqs) != 1 {
+				t.Fatalf("expected a single request for the batch, got %d", len(reqs))
+			}
+			req := reqs[0]
+			if req.path != tc.path || req.query.Encode() != tc.query.Encode() {
+				t.Errorf("expected %s?%s, got %s?%s", tc.path, tc.query.Encode(), req.path, req.query.Encode())
+			}
+			if req.auth != tc.auth {
+				t.Errorf("expected authorization %q, got %q", tc.auth, req.auth)
+			}
+			if (req.encoding == "gzip") != config.Gzip {
+				t.Errorf("expected gzip %t, got content encoding %q", config.Gzip, req.encoding)
+			}
+			if req.body != lines {
+				t.Errorf("expected the body\n%s\ngot\n%s", lines, req.body)
+			}
+		})
+	}
+}
+
+func TestInfluxWriteError(t *testing.T) {
+	var reqs []influxRequest
+	srv := influxServer(t, http.StatusBadRequest, &reqs)
+	defer srv.Close()
+
+	rcv, err := receivers.NewInflux(srv.URL, "org", "bucket", "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rcv.Collect(&extractor.Candlestick{Product: "BTC-USD", Granularity: 60, Timestamp: 1500000000})
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "bad line") {
+		t.Errorf("expected the status and response in the error, got %v", err)
+	}
+}
+
+func TestInfluxConfigInvalid(t *testing.T) {
+	for _, config := range []receivers.InfluxConfig{
+		{URL: "localhost:8086", Org: "org", Bucket: "bucket"},
+		{URL: "http://localhost:8086", Org: "org"},
+		{URL: "http://localhost:8086", Version: 2, Bucket: "bucket"},
+		{URL: "http://localhost:8086", Version: 1},
+		{URL: "http://localhost:8086", Version: 3, Database: "db", Org: "org", Bucket: "bucket"},
+	} {
+		if _, err := receivers.NewInfluxWithConfig(&config); err == nil {
+			t.Errorf("expected %+v to be rejected", config)
+		}
+	}
+}
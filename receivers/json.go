@@ -1,6 +1,7 @@
 package receivers
 
 import (
+	"bytes"
 	"encoding/json"
 	"os"
 	"sync"
@@ -15,9 +16,10 @@ type JSONRcv struct {
 	Mutex   *sync.Mutex
 }
 
-// NewJSON build a json Receiver, cretating a blank file. existing files will be overwritten
-func NewJSON(path string) (*JSONRcv, error) {
-	ptr, err := os.Create(path)
+// NewJSON build a json Receiver, cretating a blank file. existing files will be overwritten, unless
+// the append option is set
+func NewJSON(path string, appendOpt ...bool) (*JSONRcv, error) {
+	ptr, size, err := openFile(path, appendOpt)
 	if err != nil {
 		return &JSONRcv{}, err
 	}
@@ -27,8 +29,13 @@ func NewJSON(path string) (*JSONRcv, error) {
 		Pointer: ptr,
 	}
 
-	_, err = rcv.Pointer.WriteString("[\n")
+	// reopen the array when appending to a file which was closed
+	if size > 0 {
+		_, err = trimSuffix(rcv.Pointer, size, []byte("]"))
+		return rcv, err
+	}
 
+	_, err = rcv.Pointer.WriteString("[\n")
 	return rcv, err
 }
 
@@ -51,6 +58,25 @@ func (r *JSONRcv) Collect(c *extractor.Candlestick) error {
 	return err
 }
 
+// CollectBatch writes the candlesticks to the output file in a single write
+func (r *JSONRcv) CollectBatch(cdls []*extractor.Candlestick) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	var buf bytes.Buffer
+	for _, c := range cdls {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteString(",\n")
+	}
+
+	_, err := r.Pointer.Write(buf.Bytes())
+	return err
+}
+
 // Close closes the file pointer
 func (r *JSONRcv) Close() {
 	r.Mutex.Lock()
@@ -1,6 +1,7 @@
 package receivers
 
 import (
+	"bytes"
 	"encoding/json"
 	"os"
 	"sync"
@@ -15,13 +16,14 @@ type NDJSONRcv struct {
 	Mutex   *sync.Mutex
 }
 
-// NewNDJSON build a json Receiver, cretating a blank file. existing files will be overwritten
-func NewNDJSON(path string) (*JSONRcv, error) {
-	ptr, err := os.Create(path)
+// NewNDJSON build a json Receiver, cretating a blank file. existing files will be overwritten, unless
+// the append option is set
+func NewNDJSON(path string, appendOpt ...bool) (*NDJSONRcv, error) {
+	ptr, _, err := openFile(path, appendOpt)
 	if err != nil {
-		return &JSONRcv{}, err
+		return &NDJSONRcv{}, err
 	}
-	rcv := &JSONRcv{
+	rcv := &NDJSONRcv{
 		Path:    path,
 		Mutex:   &sync.Mutex{},
 		Pointer: ptr,
@@ -49,10 +51,28 @@ func (r *NDJSONRcv) Collect(c *extractor.Candlestick) error {
 	return err
 }
 
+// CollectBatch writes the candlesticks to the output file in a single write
+func (r *NDJSONRcv) CollectBatch(cdls []*extractor.Candlestick) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	var buf bytes.Buffer
+	for _, c := range cdls {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteString("\n")
+	}
+
+	_, err := r.Pointer.Write(buf.Bytes())
+	return err
+}
+
 // Close closes the file pointer
 func (r *NDJSONRcv) Close() {
 	r.Mutex.Lock()
 	defer r.Mutex.Unlock()
-	defer r.Pointer.Close()
-	r.Pointer.WriteString("]")
+	r.Pointer.Close()
 }
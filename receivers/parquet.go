@@ -0,0 +1,528 @@
+package receivers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultParquetRowGroupSize is the number of candlesticks in each row group if none is configured
+const DefaultParquetRowGroupSize = 100000
+
+// parquetMagic starts and ends every parquet file
+const parquetMagic = "PAR1"
+
+// parquet physical types, repetitions, encodings and page types of the file format
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetDataPage = 0
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMillis = 9
+
+	// the ids of the logical types in their union, and of the millisecond unit of timestamps
+	parquetLogicalString    = 1
+	parquetLogicalTimestamp = 8
+	parquetUnitMillis       = 1
+)
+
+// ParquetCompression is the codec the column pages are compressed with
+type ParquetCompression int
+
+const (
+	// ParquetSnappy compresses pages with snappy, which is fast and supported by every reader
+	ParquetSnappy ParquetCompression = iota
+	// ParquetZstd compresses pages with zstd, which is smaller than snappy at some cost in speed
+	ParquetZstd
+	// ParquetGzip compresses pages with gzip
+	ParquetGzip
+	// ParquetUncompressed leaves pages uncompressed
+	ParquetUncompressed
+)
+
+// codec is the compression codec id of the file format
+func (c ParquetCompression) codec() int32 {
+	switch c {
+	case ParquetZstd:
+		return 6
+	case ParquetGzip:
+		return 2
+	case ParquetUncompressed:
+		return 0
+	}
+	return 1
+}
+
+// ParquetConfig provides values for the parquet receiver
+type ParquetConfig struct {
+	Path string
+	// RowGroupSize is the number of candlesticks buffered in memory and written as each row group.
+	// defaults to DefaultParquetRowGroupSize
+	RowGroupSize int
+	// Compression of the column pages. defaults to ParquetSnappy
+	Compression ParquetCompression
+	// Fields are the computed candlestick fields, such as indicators, written as optional double columns
+	// after the default ones. candlesticks without a value for a field are written as null
+	Fields []string
+}
+
+// ParquetRcv implements Receiver to allow it to be used in a collector. candlesticks are written with a
+// typed schema: product and source as strings, granularity as int32, timestamp as a UTC timestamp of
+// int64 milliseconds and the prices and volume as doubles. the file is only readable once Finish has
+// written the footer
+type ParquetRcv struct {
+	Path    string
+	Pointer *os.File
+	Mutex   *sync.Mutex
+	Config  ParquetConfig
+	writer  *bufio.Writer
+	columns []parquetColumn
+	rows    []*extractor.Candlestick
+	groups  []parquetRowGroup
+	offset  int64
+	total   int64
+	zstd    *zstd.Encoder
+	// finished is set once the footer has been written, or failed to, with the error kept in err
+	finished bool
+	err      error
+}
+
+// parquetColumn is a column of the schema. value returns the plain encoded value of the candlestick, without
+// the length prefix of byte arrays, or false if it has no value
+type parquetColumn struct {
+	name      string
+	kind      int32
+	utf8      bool
+	timestamp bool
+	optional  bool
+	value     func(c *extractor.Candlestick) ([]byte, bool)
+}
+
+// parquetRowGroup is the metadata of a row group written to the file
+type parquetRowGroup struct {
+	rows    int64
+	size    int64
+	columns []parquetChunk
+}
+
+// parquetChunk is the metadata of a column chunk written to the file
+type parquetChunk struct {
+	column       *parquetColumn
+	offset       int64
+	values       int64
+	uncompressed int64
+	compressed   int64
+	stats        parquetStats
+}
+
+// parquetStats are the statistics of a column chunk. min and max are the plain encoded values, and are
+// nil if the chunk has no values to compare
+type parquetStats struct {
+	min   []byte
+	max   []byte
+	nulls int64
+}
+
+// NewParquet builds a parquet Receiver with snappy compression, creating a blank file. existing files
+// will be overwritten, as parquet files can't be appended to
+func NewParquet(path string) (*ParquetRcv, error) {
+	return NewParquetWithConfig(&ParquetConfig{Path: path})
+}
+
+// NewParquetWithConfig builds a parquet Receiver from the config, creating a blank file
+func NewParquetWithConfig(config *ParquetConfig) (*ParquetRcv, error) {
+	cfg := *config
+	if cfg.RowGroupSize <= 0 {
+		cfg.RowGroupSize = DefaultParquetRowGroupSize
+	}
+
+	rcv := &ParquetRcv{
+		Path:    cfg.Path,
+		Mutex:   &sync.Mutex{},
+		Config:  cfg,
+		columns: parquetColumns(cfg.Fields),
+	}
+	if cfg.Compression == ParquetZstd {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return &ParquetRcv{}, err
+		}
+		rcv.zstd = enc
+	}
+
+	ptr, err := os.Create(cfg.Path)
+	if err != nil {
+		return &ParquetRcv{}, err
+	}
+	rcv.Pointer = ptr
+	rcv.writer = bufio.NewWriter(ptr)
+	if _, err = rcv.writer.WriteString(parquetMagic); err != nil {
+		return rcv, err
+	}
+	rcv.offset = int64(len(parquetMagic))
+	return rcv, nil
+}
+
+// Collect buffers the candlestick, writing a row group once enough are buffered
+func (r *ParquetRcv) Collect(c *extractor.Candlestick) error {
+	return r.CollectBatch([]*extractor.Candlestick{c})
+}
+
+// CollectBatch buffers the candlesticks, writing a row group each time enough are buffered
+func (r *ParquetRcv) CollectBatch(cdls []*extractor.Candlestick) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	if r.finished {
+		return fmt.Errorf("Parquet file [%s] is already finished", r.Path)
+	}
+	for _, c := range cdls {
+		r.rows = append(r.rows, c)
+		if len(r.rows) >= r.Config.RowGroupSize {
+			if err := r.writeRowGroup(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Finish writes the buffered candlesticks and the footer, and closes the file pointer. the file is only
+// readable if it returns nil. Finish implements extractor.Finisher, so the collector reports its error
+func (r *ParquetRcv) Finish() error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	if r.finished {
+		return r.err
+	}
+	r.finished = true
+	r.err = r.finish()
+	if r.zstd != nil {
+		r.zstd.Close()
+	}
+	if err := r.Pointer.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if r.err != nil {
+		r.err = fmt.Errorf("Failed to write parquet file [%s]: %s", r.Path, r.err.Error())
+	}
+	return r.err
+}
+
+// Close finishes the file if it hasn't been already. the error is returned by Finish, which should be called
+// first when the receiver isn't used in a collector
+func (r *ParquetRcv) Close() {
+	r.Finish()
+}
+
+func (r *ParquetRcv) finish() error {
+	if err := r.writeRowGroup(); err != nil {
+		return err
+	}
+	footer := r.footer()
+	if _, err := r.writer.Write(footer); err != nil {
+		return err
+	}
+	if err := binary.Write(r.writer, binary.LittleEndian, uint32(len(footer))); err != nil {
+		return err
+	}
+	if _, err := r.writer.WriteString(parquetMagic); err != nil {
+		return err
+	}
+	return r.writer.Flush()
+}
+
+// writeRowGroup writes the buffered candlesticks as a row group, with a single data page for each column
+func (r *ParquetRcv) writeRowGroup() error {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: int64(len(r.rows))}
+	for i := range r.columns {
+		col := &r.columns[i]
+		header, body, size, stats, err := r.page(col)
+		if err != nil {
+			return fmt.Errorf("Failed to encode parquet column [%s]: %s", col.name, err.Error())
+		}
+		if _, err = r.writer.Write(append(header, body...)); err != nil {
+			return fmt.Errorf("Failed to write parquet file [%s]: %s", r.Path, err.Error())
+		}
+		chunk := parquetChunk{
+			column:       col,
+			offset:       r.offset,
+			values:       group.rows,
+			uncompressed: int64(len(header) + size),
+			compressed:   int64(len(header) + len(body)),
+			stats:        stats,
+		}
+		r.offset += chunk.compressed
+		group.size += chunk.uncompressed
+		group.columns = append(group.columns, chunk)
+	}
+	r.groups = append(r.groups, group)
+	r.total += group.rows
+	r.rows = r.rows[:0]
+	return nil
+}
+
+// page builds the header and compressed body of the column's data page for the buffered candlesticks,
+// returning the uncompressed size of the body and the statistics of the values with them
+func (r *ParquetRcv) page(col *parquetColumn) ([]byte, []byte, int, parquetStats, error) {
+	var values bytes.Buffer
+	var stats parquetStats
+	defined := make([]bool, len(r.rows))
+	for i, c := range r.rows {
+		v, ok := col.value(c)
+		defined[i] = ok
+		if !ok {
+			stats.nulls++
+			continue
+		}
+		if col.kind == parquetByteArray {
+			binary.Write(&values, binary.LittleEndian, uint32(len(v)))
+		}
+		values.Write(v)
+		// NaN isn't ordered, so it's left out of the min and max
+		if col.kind == parquetDouble && math.IsNaN(math.Float64frombits(binary.LittleEndian.Uint64(v))) {
+			continue
+		}
+		if stats.min == nil || parquetLess(col.kind, v, stats.min) {
+			stats.min = v
+		}
+		if stats.max == nil || parquetLess(col.kind, stats.max, v) {
+			stats.max = v
+		}
+	}
+
+	var body bytes.Buffer
+	if col.optional {
+		levels := definitionLevels(defined)
+		binary.Write(&body, binary.LittleEndian, uint32(len(levels)))
+		body.Write(levels)
+	}
+	body.Write(values.Bytes())
+
+	compressed, err := r.compress(body.Bytes())
+	if err != nil {
+		return nil, nil, 0, stats, err
+	}
+
+	w := newCompactWriter()
+	w.i32(1, parquetDataPage)
+	w.i32(2, int32(body.Len()))
+	w.i32(3, int32(len(compressed)))
+	w.beginStruct(5)
+	w.i32(1, int32(len(r.rows)))
+	w.i32(2, parquetPlain)
+	w.i32(3, parquetRLE)
+	w.i32(4, parquetRLE)
+	w.end()
+	w.end()
+	return w.buf.Bytes(), compressed, body.Len(), stats, nil
+}
+
+func (r *ParquetRcv) compress(b []byte) ([]byte, error) {
+	switch r.Config.Compression {
+	case ParquetZstd:
+		return r.zstd.EncodeAll(b, nil), nil
+	case ParquetGzip:
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(b); err != nil {
+			return nil, err
+		}
+		err := gz.Close()
+		return buf.Bytes(), err
+	case ParquetUncompressed:
+		return b, nil
+	}
+	return snappy.Encode(nil, b), nil
+}
+
+// footer encodes the file metadata: the schema, and the location and statistics of every column chunk
+func (r *ParquetRcv) footer() []byte {
+	w := newCompactWriter()
+	w.i32(1, 1)
+
+	w.list(2, thriftStruct, len(r.columns)+1)
+	w.beginElem()
+	w.string(4, "schema")
+	w.i32(5, int32(len(r.columns)))
+	w.end()
+	for _, col := range r.columns {
+		w.beginElem()
+		w.i32(1, col.kind)
+		repetition := int32(parquetRequired)
+		if col.optional {
+			repetition = parquetOptional
+		}
+		w.i32(3, repetition)
+		w.string(4, col.name)
+		switch {
+		case col.utf8:
+			w.i32(6, parquetConvertedUTF8)
+			w.beginStruct(10)
+			w.beginStruct(parquetLogicalString)
+			w.end()
+			w.end()
+		case col.timestamp:
+			w.i32(6, parquetConvertedTimestampMillis)
+			w.beginStruct(10)
+			w.beginStruct(parquetLogicalTimestamp)
+			w.bool(1, true)
+			w.beginStruct(2)
+			w.beginStruct(parquetUnitMillis)
+			w.end()
+			w.end()
+			w.end()
+			w.end()
+		}
+		w.end()
+	}
+
+	w.i64(3, r.total)
+
+	w.list(4, thriftStruct, len(r.groups))
+	for _, group := range r.groups {
+		w.beginElem()
+		w.list(1, thriftStruct, len(group.columns))
+		for _, chunk := range group.columns {
+			w.beginElem()
+			w.i64(2, chunk.offset)
+			w.beginStruct(3)
+			w.i32(1, chunk.column.kind)
+			w.list(2, thriftI32, 2)
+			w.i32Elem(parquetPlain)
+			w.i32Elem(parquetRLE)
+			w.list(3, thriftBinary, 1)
+			w.stringElem(chunk.column.name)
+			w.i32(4, r.Config.Compression.codec())
+			w.i64(5, chunk.values)
+			w.i64(6, chunk.uncompressed)
+			w.i64(7, chunk.compressed)
+			w.i64(9, chunk.offset)
+			w.beginStruct(12)
+			w.i64(3, chunk.stats.nulls)
+			if chunk.stats.max != nil {
+				w.binary(5, chunk.stats.max)
+				w.binary(6, chunk.stats.min)
+			}
+			w.end()
+			w.end()
+			w.end()
+		}
+		w.i64(2, group.size)
+		w.i64(3, group.rows)
+		w.end()
+	}
+
+	w.string(6, "gdax-candle-extractor")
+
+	// every column's statistics are in the order of its type
+	w.list(7, thriftStruct, len(r.columns))
+	for range r.columns {
+		w.beginElem()
+		w.beginStruct(1)
+		w.end()
+		w.end()
+	}
+	w.end()
+	return w.buf.Bytes()
+}
+
+// parquetLess compares plain encoded values of the physical type, signed for integers and unsigned bytewise
+// for byte arrays
+func parquetLess(kind int32, a, b []byte) bool {
+	switch kind {
+	case parquetInt32:
+		return int32(binary.LittleEndian.Uint32(a)) < int32(binary.LittleEndian.Uint32(b))
+	case parquetInt64:
+		return int64(binary.LittleEndian.Uint64(a)) < int64(binary.LittleEndian.Uint64(b))
+	case parquetDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(a)) < math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+// definitionLevels encodes whether each value is defined with the bit-packed encoding of the RLE hybrid,
+// for a maximum definition level of 1
+func definitionLevels(defined []bool) []byte {
+	groups := (len(defined) + 7) / 8
+	w := newCompactWriter()
+	w.varint(uint64(groups)<<1 | 1)
+	packed := make([]byte, groups)
+	for i, d := range defined {
+		if d {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	w.buf.Write(packed)
+	return w.buf.Bytes()
+}
+
+// parquetColumns is the schema of the candlesticks, followed by a column for each computed field
+func parquetColumns(fields []string) []parquetColumn {
+	str := func(name string, get func(*extractor.Candlestick) string) parquetColumn {
+		return parquetColumn{name: name, kind: parquetByteArray, utf8: true, value: func(c *extractor.Candlestick) ([]byte, bool) {
+			return []byte(get(c)), true
+		}}
+	}
+	double := func(name string, get func(*extractor.Candlestick) float64) parquetColumn {
+		return parquetColumn{name: name, kind: parquetDouble, value: func(c *extractor.Candlestick) ([]byte, bool) {
+			return plainUint64(math.Float64bits(get(c))), true
+		}}
+	}
+
+	cols := []parquetColumn{
+		{name: "timestamp", kind: parquetInt64, timestamp: true, value: func(c *extractor.Candlestick) ([]byte, bool) {
+			return plainUint64(uint64(c.Timestamp * 1000)), true
+		}},
+		str("product", func(c *extractor.Candlestick) string { return c.Product }),
+		str("source", func(c *extractor.Candlestick) string { return c.Source }),
+		{name: "granularity", kind: parquetInt32, value: func(c *extractor.Candlestick) ([]byte, bool) {
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, uint32(int32(c.Granularity)))
+			return b, true
+		}},
+		double("low", func(c *extractor.Candlestick) float64 { return c.Low }),
+		double("high", func(c *extractor.Candlestick) float64 { return c.High }),
+		double("open", func(c *extractor.Candlestick) float64 { return c.Open }),
+		double("close", func(c *extractor.Candlestick) float64 { return c.Close }),
+		double("volume", func(c *extractor.Candlestick) float64 { return c.Volume }),
+	}
+	for _, f := range fields {
+		name := f
+		cols = append(cols, parquetColumn{name: name, kind: parquetDouble, optional: true, value: func(c *extractor.Candlestick) ([]byte, bool) {
+			v, ok := c.Fields[name]
+			if !ok {
+				return nil, false
+			}
+			return plainUint64(math.Float64bits(v)), true
+		}})
+	}
+	return cols
+}
+
+func plainUint64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
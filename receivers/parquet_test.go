@@ -0,0 +1,484 @@
+package receivers_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/receivers"
+	"github.com/klauspost/compress/zstd"
+)
+
+// thriftReader decodes the thrift compact protocol into generic values: structs as maps of field id to
+// value, lists as slices, integers as int64 and binary as []byte
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.b[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(kind byte) interface{} {
+	switch kind {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, 5, 6:
+		return r.zigzag()
+	case 7:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos:]))
+		r.pos += 8
+		return v
+	case 8:
+		n := int(r.varint())
+		v := r.b[r.pos : r.pos+n]
+		r.pos += n
+		return v
+	case 9, 10:
+		header := r.byte()
+		size, elem := int(header>>4), header&0x0f
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case 12:
+		return r.structure()
+	}
+	panic(fmt.Sprintf("unsupported thrift type %d", kind))
+}
+
+func (r *thriftReader) structure() map[int]interface{} {
+	fields := map[int]interface{}{}
+	id := 0
+	for {
+		header := r.byte()
+		if header == 0 {
+			return fields
+		}
+		if delta := int(header >> 4); delta > 0 {
+			id += delta
+		} else {
+			id = int(r.zigzag())
+		}
+		fields[id] = r.value(header & 0x0f)
+	}
+}
+
+// parquetFile is the decoded footer of a parquet file, and its raw bytes
+type parquetFile struct {
+	raw  []byte
+	meta map[int]interface{}
+}
+
+func readParquet(t *testing.T, path string) *parquetFile {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) < 12 || string(raw[:4]) != "PAR1" || string(raw[len(raw)-4:]) != "PAR1" {
+		t.Fatalf("expected the file to start and end with the parquet magic")
+	}
+	size := int(binary.LittleEndian.Uint32(raw[len(raw)-8:]))
+	footer := &thriftReader{b: raw[len(raw)-8-size : len(raw)-8]}
+	meta := footer.structure()
+	if footer.pos != size {
+		t.Fatalf("expected a footer of %d bytes, decoded %d", size, footer.pos)
+	}
+	return &parquetFile{raw: raw, meta: meta}
+}
+
+// column reads every page of the column from each row group, returning the values and whether each row
+// is defined
+func (f *parquetFile) column(t *testing.T, index int, kind int64, optional bool, codec int64) ([]interface{}, []bool) {
+	var values []interface{}
+	var defined []bool
+	for _, g := range f.meta[4].([]interface{}) {
+		chunk := g.(map[int]interface{})[1].([]interface{})[index].(map[int]interface{})
+		meta := chunk[3].(map[int]interface{})
+		if meta[1].(int64) != kind || meta[4].(int64) != codec {
+			t.Fatalf("column %d: expected type %d and codec %d, got %d and %d", index, kind, codec, meta[1], meta[4])
+		}
+
+		// the chunk's values may be split across several data pages
+		page := &thriftReader{b: f.raw, pos: int(meta[9].(int64))}
+		for read := 0; read < int(meta[5].(int64)); {
+			header := page.structure()
+			rows := int(header[5].(map[int]interface{})[1].(int64))
+			compressed := f.raw[page.pos : page.pos+int(header[3].(int64))]
+			page.pos += len(compressed)
+			read += rows
+			body := decompress(t, codec, compressed)
+			if len(body) != int(header[2].(int64)) {
+				t.Fatalf("column %d: expected %d uncompressed bytes, got %d", index, header[2], len(body))
+			}
+
+			def := make([]bool, rows)
+			for i := range def {
+				def[i] = true
+			}
+			if optional {
+				n := int(binary.LittleEndian.Uint32(body))
+				levels := &thriftReader{b: body[4 : 4+n]}
+				groups := int(levels.varint() >> 1)
+				packed := levels.b[levels.pos : levels.pos+groups]
+				for i := range def {
+					def[i] = packed[i/8]&(1<<uint(i%8)) != 0
+				}
+				body = body[4+n:]
+			}
+
+			vals := bytes.NewReader(body)
+			for _, d := range def {
+				defined = append(defined, d)
+				if !d {
+					values = append(values, nil)
+					continue
+				}
+				switch kind {
+				case 1:
+					var v int32
+					binary.Read(vals, binary.LittleEndian, &v)
+					values = append(values, int64(v))
+				case 2:
+					var v int64
+					binary.Read(vals, binary.LittleEndian, &v)
+					values = append(values, v)
+				case 5:
+					var v uint64
+					binary.Read(vals, binary.LittleEndian, &v)
+					values = append(values, math.Float64frombits(v))
+				case 6:
+					var n uint32
+					binary.Read(vals, binary.LittleEndian, &n)
+					s := make([]byte, n)
+					vals.Read(s)
+					values = append(values, string(s))
+				}
+			}
+			if vals.Len() != 0 {
+				t.Fatalf("column %d: %d bytes left after the values", index, vals.Len())
+			}
+		}
+	}
+	return values, defined
+}
+
+// plainValue decodes a plain encoded statistic of the physical type, as the values are decoded by column
+func plainValue(kind int64, b []byte) interface{} {
+	switch kind {
+	case 1:
+		return int64(int32(binary.LittleEndian.Uint32(b)))
+	case 2:
+		return int64(binary.LittleEndian.Uint64(b))
+	case 5:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return string(b)
+}
+
+func decompress(t *testing.T, codec int64, b []byte) []byte {
+	var out []byte
+	var err error
+	switch codec {
+	case 0:
+		return b
+	case 1:
+		out, err = snappy.Decode(nil, b)
+	case 2:
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(b)); err == nil {
+			out, err = ioutil.ReadAll(gz)
+		}
+	case 6:
+		var dec *zstd.Decoder
+		if dec, err = zstd.NewReader(nil); err == nil {
+			out, err = dec.DecodeAll(b, nil)
+			dec.Close()
+		}
+	default:
+		t.Fatalf("unexpected codec %d", codec)
+	}
+	if err != nil {
+		t.Fatalf("failed to decompress codec %d: %s", codec, err)
+	}
+	return out
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	var cdls []*extractor.Candlestick
+	for i := 0; i < 25; i++ {
+		c := &extractor.Candlestick{
+			Product:     "BTC-USD",
+			Source:      "gdax",
+			Granularity: 60,
+			Timestamp:   1500000000 + int64(i)*60,
+			Low:         100 + float64(i),
+			High:        110 + float64(i),
+			Open:        105 + float64(i),
+			Close:       106.5 + float64(i),
+			Volume:      0.25 * float64(i),
+		}
+		// the computed field is missing from some candlesticks, so it's written as null, and NaN in one
+		if i%3 != 0 {
+			c.Fields = map[string]float64{"sma_3": 1000 - float64(i)}
+		}
+		if i == 13 {
+			c.Fields["sma_3"] = math.NaN()
+		}
+		cdls = append(cdls, c)
+	}
+
+	schema := []struct {
+		name     string
+		kind     int64
+		optional bool
+		value    func(*extractor.Candlestick) interface{}
+	}{
+		{"timestamp", 2, false, func(c *extractor.Candlestick) interface{} { return c.Timestamp * 1000 }},
+		{"product", 6, false, func(c *extractor.Candlestick) interface{} { return c.Product }},
+		{"source", 6, false, func(c *extractor.Candlestick) interface{} { return c.Source }},
+		{"granularity", 1, false, func(c *extractor.Candlestick) interface{} { return int64(c.Granularity) }},
+		{"low", 5, false, func(c *extractor.Candlestick) interface{} { return c.Low }},
+		{"high", 5, false, func(c *extractor.Candlestick) interface{} { return c.High }},
+		{"open", 5, false, func(c *extractor.Candlestick) interface{} { return c.Open }},
+		{"close", 5, false, func(c *extractor.Candlestick) interface{} { return c.Close }},
+		{"volume", 5, false, func(c *extractor.Candlestick) interface{} { return c.Volume }},
+		{"sma_3", 5, true, func(c *extractor.Candlestick) interface{} {
+			if v, ok := c.Fields["sma_3"]; ok {
+				return v
+			}
+			return nil
+		}},
+	}
+
+	codecs := []struct {
+		name        string
+		compression receivers.ParquetCompression
+		codec       int64
+	}{
+		{"snappy", receivers.ParquetSnappy, 1},
+		{"zstd", receivers.ParquetZstd, 6},
+		{"gzip", receivers.ParquetGzip, 2},
+		{"uncompressed", receivers.ParquetUncompressed, 0},
+	}
+	for _, tc := range codecs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "parquet")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			rcv, err := receivers.NewParquetWithConfig(&receivers.ParquetConfig{
+				Path:         filepath.Join(dir, "candles.parquet"),
+				RowGroupSize: 10,
+				Compression:  tc.compression,
+				Fields:       []string{"sma_3"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = rcv.CollectBatch(cdls); err != nil {
+				t.Fatal(err)
+			}
+			if err = rcv.Finish(); err != nil {
+				t.Fatal(err)
+			}
+			// closing a finished file does nothing, and it can't be written to
+			rcv.Close()
+			if err = rcv.Collect(cdls[0]); err == nil {
+				t.Error("expected collecting to a finished file to fail")
+			}
+
+			f := readParquet(t, rcv.Path)
+			if f.meta[1].(int64) != 1 || f.meta[3].(int64) != int64(len(cdls)) {
+				t.Fatalf("expected version 1 with %d rows, got %v and %v", len(cdls), f.meta[1], f.meta[3])
+			}
+			if groups := f.meta[4].([]interface{}); len(groups) != 3 {
+				t.Fatalf("expected 3 row groups of at most 10 rows, got %d", len(groups))
+			}
+
+			elems := f.meta[2].([]interface{})
+			root := elems[0].(map[int]interface{})
+			if string(root[4].([]byte)) != "schema" || root[5].(int64) != int64(len(schema)) || len(elems) != len(schema)+1 {
+				t.Fatalf("expected a root schema element with %d children, got %v", len(schema), root)
+			}
+			for i, col := range schema {
+				elem := elems[i+1].(map[int]interface{})
+				repetition := int64(0)
+				if col.optional {
+					repetition = 1
+				}
+				if string(elem[4].([]byte)) != col.name || elem[1].(int64) != col.kind || elem[3].(int64) != repetition {
+					t.Fatalf("schema element %d: expected %s of type %d, got %v", i, col.name, col.kind, elem)
+				}
+				switch col.name {
+				case "timestamp":
+					// TIMESTAMP_MILLIS, and a timestamp of milliseconds adjusted to UTC
+					unit := map[int]interface{}{1: map[int]interface{}{}}
+					logical := map[int]interface{}{8: map[int]interface{}{1: true, 2: unit}}
+					if elem[6] != int64(9) || !reflect.DeepEqual(elem[10], logical) {
+						t.Errorf("schema element %s: expected a UTC millisecond timestamp, got %v", col.name, elem)
+					}
+				case "product", "source":
+					logical := map[int]interface{}{1: map[int]interface{}{}}
+					if elem[6] != int64(0) || !reflect.DeepEqual(elem[10], logical) {
+						t.Errorf("schema element %s: expected a UTF8 string, got %v", col.name, elem)
+					}
+				default:
+					if _, ok := elem[6]; ok {
+						t.Errorf("schema element %s: expected no converted type, got %v", col.name, elem)
+					}
+				}
+
+				values, defined := f.column(t, i, col.kind, col.optional, tc.codec)
+				if len(values) != len(cdls) {
+					t.Fatalf("column %s: expected %d values, got %d", col.name, len(cdls), len(values))
+				}
+				for j, c := range cdls {
+					want := col.value(c)
+					if f, ok := want.(float64); ok && math.IsNaN(f) {
+						if got, ok := values[j].(float64); !ok || !math.IsNaN(got) {
+							t.Fatalf("column %s row %d: expected NaN, got %v", col.name, j, values[j])
+						}
+						continue
+					}
+					if values[j] != want || defined[j] != (want != nil) {
+						t.Fatalf("column %s row %d: expected %v, got %v", col.name, j, want, values[j])
+					}
+				}
+
+				// the statistics of each row group's chunk, leaving out nulls and NaN
+				for g, group := range f.meta[4].([]interface{}) {
+					chunk := group.(map[int]interface{})[1].([]interface{})[i].(map[int]interface{})
+					stats := chunk[3].(map[int]interface{})[12].(map[int]interface{})
+					var min, max interface{}
+					nulls := int64(0)
+					for _, c := range cdls[g*10 : int(math.Min(float64(g*10+10), float64(len(cdls))))] {
+						v := col.value(c)
+						if v == nil {
+							nulls++
+							continue
+						}
+						if f, ok := v.(float64); ok && math.IsNaN(f) {
+							continue
+						}
+						if min == nil || less(v, min) {
+							min = v
+						}
+						if max == nil || less(max, v) {
+							max = v
+						}
+					}
+					gotMin, gotMax := plainValue(col.kind, stats[6].([]byte)), plainValue(col.kind, stats[5].([]byte))
+					if stats[3] != nulls || gotMin != min || gotMax != max {
+						t.Errorf("column %s row group %d: expected min %v, max %v and %d nulls, got %v", col.name, g, min, max, nulls, stats)
+					}
+				}
+			}
+
+			orders := f.meta[7].([]interface{})
+			if len(orders) != len(schema) || !reflect.DeepEqual(orders[0], map[int]interface{}{1: map[int]interface{}{}}) {
+				t.Errorf("expected each column to be in the order of its type, got %v", orders)
+			}
+		})
+	}
+}
+
+// less orders the decoded values of a column
+func less(a, b interface{}) bool {
+	switch v := a.(type) {
+	case int64:
+		return v < b.(int64)
+	case float64:
+		return v < b.(float64)
+	}
+	return a.(string) < b.(string)
+}
+
+func TestParquetFinishError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rcv, err := receivers.NewParquet(filepath.Join(dir, "candles.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rcv.Collect(&extractor.Candlestick{Product: "BTC-USD", Granularity: 60, Timestamp: 1500000000}); err != nil {
+		t.Fatal(err)
+	}
+	// the footer can't be written once the file is closed underneath the receiver
+	rcv.Pointer.Close()
+	if err = rcv.Finish(); err == nil {
+		t.Fatal("expected the failure to write the footer to be returned")
+	}
+	if again := rcv.Finish(); again == nil || again.Error() != err.Error() {
+		t.Errorf("expected finishing again to return the same error, got %v", again)
+	}
+}
+
+// TestParquetReaderFixture checks the footer and page decoding the tests rely on against a file written by
+// another implementation: the flat example of github.com/xitongsys/parquet-go, of 10 students with ids 0
+// to 9 and ages 20 to 24, compressed with snappy. the file is copied from github.com/xitongsys/parquet-go-source
+// under the Apache License 2.0
+func TestParquetReaderFixture(t *testing.T) {
+	f := readParquet(t, filepath.Join("testdata", "flat.parquet.snappy"))
+	if f.meta[1].(int64) != 1 || f.meta[3].(int64) != 10 {
+		t.Fatalf("expected version 1 with 10 rows, got %v and %v", f.meta[1], f.meta[3])
+	}
+	var names []string
+	for _, e := range f.meta[2].([]interface{})[1:] {
+		names = append(names, string(e.(map[int]interface{})[4].([]byte)))
+	}
+	if want := []string{"name", "age", "id", "weight", "sex", "day"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected the columns %v, got %v", want, names)
+	}
+
+	ages, _ := f.column(t, 1, 1, false, 1)
+	ids, _ := f.column(t, 2, 2, false, 1)
+	for i := 0; i < 10; i++ {
+		if ages[i] != int64(20+i%5) || ids[i] != int64(i) {
+			t.Errorf("row %d: expected age %d and id %d, got %v and %v", i, 20+i%5, i, ages[i], ids[i])
+		}
+	}
+	// parquet-go writes the deprecated min and max fields of the statistics
+	chunk := f.meta[4].([]interface{})[0].(map[int]interface{})[1].([]interface{})[2].(map[int]interface{})
+	stats := chunk[3].(map[int]interface{})[12].(map[int]interface{})
+	if plainValue(2, stats[2].([]byte)) != int64(0) || plainValue(2, stats[1].([]byte)) != int64(9) {
+		t.Errorf("expected ids from 0 to 9, got %v", stats)
+	}
+}
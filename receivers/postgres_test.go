@@ -0,0 +1,157 @@
+package receivers_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/receivers"
+)
+
+// candles builds a minute candlestick for each of the timestamps, closing at the price
+func candles(product string, close float64, timestamps ...int64) []*extractor.Candlestick {
+	var cdls []*extractor.Candlestick
+	for _, ts := range timestamps {
+		cdls = append(cdls, &extractor.Candlestick{
+			Product:     product,
+			Source:      "gdax",
+			Granularity: 60,
+			Timestamp:   ts,
+			Low:         close - 1,
+			High:        close + 1,
+			Open:        close,
+			Close:       close,
+			Volume:      1,
+		})
+	}
+	return cdls
+}
+
+// pgTest returns the DSN of the database the Postgres tests run against, and a table name unique to the
+// test. the tests are skipped unless GDAX_EXTRACTOR_TEST_PG_DSN is set, see docker-compose.test.yml
+func pgTest(t *testing.T) (string, string) {
+	dsn := os.Getenv("GDAX_EXTRACTOR_TEST_PG_DSN")
+	if dsn == "" {
+		t.Skip("GDAX_EXTRACTOR_TEST_PG_DSN is not set")
+	}
+	return dsn, fmt.Sprintf("candles_test_%d", time.Now().UnixNano())
+}
+
+// dropTable removes the test's table once it's done
+func dropTable(t *testing.T, dsn string, table string) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+	if _, err = db.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresUpsert(t *testing.T) {
+	dsn, prefix := pgTest(t)
+	for _, mode := range []struct {
+		name string
+		copy bool
+	}{{"insert", false}, {"copy", true}} {
+		t.Run(mode.name, func(t *testing.T) {
+			table := prefix + "_" + mode.name
+			defer dropTable(t, dsn, table)
+
+			// the second collection overlaps the end of the first with new closes, and repeats a candlestick
+			// within the batch, of which only the last is written. each is split into statements of 2 rows
+			first := candles("BTC-USD", 100, 0, 60, 120, 180)
+			second := append(candles("BTC-USD", 200, 120, 180, 240), candles("BTC-USD", 300, 180)...)
+			second[0].Fields = map[string]float64{"sma_3": 150}
+			other := candles("ETH-USD", 10, 0, 60)
+			for i, batch := range [][]*extractor.Candlestick{first, second, other} {
+				rcv, err := receivers.NewPostgresWithConfig(&receivers.PGConfig{DSN: dsn, Table: table, Copy: mode.copy, BatchSize: 2})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = rcv.CollectBatch(batch); err != nil {
+					t.Fatalf("collection %d: %s", i, err)
+				}
+				rcv.Close()
+			}
+
+			rcv, err := receivers.NewPostgresWithConfig(&receivers.PGConfig{DSN: dsn, Table: table})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rcv.Close()
+
+			var count int
+			if err = rcv.DB.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if count != 7 {
+				t.Errorf("expected 7 rows, one per product and time, got %d", count)
+			}
+
+			rows, err := rcv.DB.Query(`SELECT EXTRACT(EPOCH FROM time)::BIGINT, close, COALESCE(fields->>'sma_3', '')
+				FROM ` + table + ` WHERE product = 'BTC-USD' ORDER BY time`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			want := []struct {
+				ts    int64
+				close float64
+				sma   string
+			}{{0, 100, ""}, {60, 100, ""}, {120, 200, "150"}, {180, 300, ""}, {240, 200, ""}}
+			i := 0
+			for ; rows.Next(); i++ {
+				var ts int64
+				var close float64
+				var sma string
+				if err = rows.Scan(&ts, &close, &sma); err != nil {
+					t.Fatal(err)
+				}
+				if i >= len(want) {
+					t.Fatalf("unexpected row %d: %d %f %q", i, ts, close, sma)
+				}
+				// the later collection wins where they overlap, and the last of a repeated candlestick
+				if ts != want[i].ts || close != want[i].close || sma != want[i].sma {
+					t.Errorf("row %d: expected %+v, got %d %f %q", i, want[i], ts, close, sma)
+				}
+			}
+			if err = rows.Err(); err != nil || i != len(want) {
+				t.Errorf("expected %d BTC-USD rows, got %d %v", len(want), i, err)
+			}
+		})
+	}
+}
+
+func TestPostgresHypertable(t *testing.T) {
+	dsn, table := pgTest(t)
+	defer dropTable(t, dsn, table)
+
+	// created again for an existing hypertable, as a resumed extraction would
+	for i := 0; i < 2; i++ {
+		rcv, err := receivers.NewPostgresWithConfig(&receivers.PGConfig{DSN: dsn, Table: table, Hypertable: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = rcv.CollectBatch(candles("BTC-USD", 100, 0, 60)); err != nil {
+			t.Fatal(err)
+		}
+		rcv.Close()
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var dimension string
+	err = db.QueryRow(`SELECT column_name FROM timescaledb_information.dimensions WHERE hypertable_name = $1`, table).Scan(&dimension)
+	if err != nil || dimension != "time" {
+		t.Errorf("expected a hypertable partitioned by time, got %q %v", dimension, err)
+	}
+}
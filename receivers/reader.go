@@ -0,0 +1,163 @@
+package receivers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+)
+
+// datetimeFmt is the format of the candlestick datetime string
+const datetimeFmt = "2006-01-02 15:04:05 -0700 MST"
+
+// CandleReader reads candlesticks back from the output of a file receiver. Read returns io.EOF once
+// the file is exhausted
+type CandleReader interface {
+	Read() (*extractor.Candlestick, error)
+}
+
+// CSVReader implements CandleReader for files written by CSVRcv, including files written before the
+// product and source columns were added. columns beyond the default ones are read into the candlestick's Fields
+type CSVReader struct {
+	Reader *csv.Reader
+	cols   map[string]int
+}
+
+// NewCSVReader builds a CSV reader. the header row is read with the first candlestick
+func NewCSVReader(r io.Reader) *CSVReader {
+	rdr := csv.NewReader(r)
+	// resumed files may mix rows written before and after columns were added
+	rdr.FieldsPerRecord = -1
+	return &CSVReader{Reader: rdr}
+}
+
+// Read returns the next candlestick in the file
+func (r *CSVReader) Read() (*extractor.Candlestick, error) {
+	row, err := r.Reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.cols == nil {
+		r.cols = columns(row)
+		if _, ok := r.cols["time"]; ok {
+			return r.Read()
+		}
+		// headerless file, fall back to the default layout
+		r.cols = columns(csvHeader)
+	}
+
+	cols := r.cols
+	if len(row) > len(cols) {
+		cols = columns(csvHeader)
+	}
+	field := func(name string) string {
+		if i, ok := cols[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	c := &extractor.Candlestick{
+		Datetime: field("time"),
+		Product:  field("product"),
+		Source:   field("source"),
+	}
+	if c.Granularity, err = strconv.Atoi(field("granularity")); err != nil {
+		return nil, fmt.Errorf("Invalid CSV granularity [%s]: %s", field("granularity"), err.Error())
+	}
+	for name, f := range map[string]*float64{"low": &c.Low, "high": &c.High, "open": &c.Open, "close": &c.Close, "volume": &c.Volume} {
+		if *f, err = sToF(field(name)); err != nil {
+			return nil, fmt.Errorf("Invalid CSV %s [%s]: %s", name, field(name), err.Error())
+		}
+	}
+	// any other columns are computed fields, such as indicators, left empty where there's no value
+	known := columns(csvHeader)
+	for name := range cols {
+		if _, ok := known[name]; ok || field(name) == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(field(name), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CSV %s [%s]: %s", name, field(name), err.Error())
+		}
+		if c.Fields == nil {
+			c.Fields = map[string]float64{}
+		}
+		c.Fields[name] = v
+	}
+	t, err := time.Parse(datetimeFmt, c.Datetime)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV time [%s]: %s", c.Datetime, err.Error())
+	}
+	c.Timestamp = t.Unix()
+	return c, nil
+}
+
+// JSONReader implements CandleReader for files written by JSONRcv and NDJSONRcv. each candlestick is
+// expected on its own line, which allows the unterminated arrays of interrupted extractions to be read
+type JSONReader struct {
+	Scanner *bufio.Scanner
+}
+
+// NewJSONReader builds a reader for JSON array files
+func NewJSONReader(r io.Reader) *JSONReader {
+	scn := bufio.NewScanner(r)
+	scn.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &JSONReader{Scanner: scn}
+}
+
+// NewNDJSONReader builds a reader for newline delimited JSON files
+func NewNDJSONReader(r io.Reader) *JSONReader {
+	return NewJSONReader(r)
+}
+
+// Read returns the next candlestick in the file
+func (r *JSONReader) Read() (*extractor.Candlestick, error) {
+	for r.Scanner.Scan() {
+		line := bytes.TrimSpace(r.Scanner.Bytes())
+		line = bytes.TrimSuffix(bytes.TrimPrefix(line, []byte("[")), []byte("]"))
+		line = bytes.TrimSpace(bytes.TrimSuffix(line, []byte(",")))
+		if len(line) == 0 {
+			continue
+		}
+
+		c := &extractor.Candlestick{}
+		if err := json.Unmarshal(line, c); err != nil {
+			return nil, fmt.Errorf("Invalid JSON candlestick [%s]: %s", string(line), err.Error())
+		}
+		if c.Timestamp == 0 && c.Datetime != "" {
+			if t, err := time.Parse(datetimeFmt, c.Datetime); err == nil {
+				c.Timestamp = t.Unix()
+			}
+		}
+		return c, nil
+	}
+	if err := r.Scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func columns(header []string) map[string]int {
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	return cols
+}
+
+// sToF parses floats written by fToS, which writes zero as "."
+func sToF(s string) (float64, error) {
+	if s == "." || s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
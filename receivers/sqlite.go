@@ -0,0 +1,164 @@
+package receivers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	// registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// DefaultSQLiteTable is the table candlesticks are written to if none is configured
+	DefaultSQLiteTable = "candles"
+	// DefaultSQLiteBatchSize is the most candlesticks written in a single transaction if none is configured
+	DefaultSQLiteBatchSize = 500
+)
+
+// sqlIdentifier matches table names which are safe to use unquoted
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLiteConfig provides values for the SQLite receiver
+type SQLiteConfig struct {
+	Path string
+	// Table is created if it doesn't exist. defaults to DefaultSQLiteTable
+	Table string
+	// BatchSize is the most candlesticks written in a single transaction. defaults to DefaultSQLiteBatchSize
+	BatchSize int
+}
+
+// SQLiteRcv implements Receiver to allow it to be used in a collector. candlesticks are keyed by product,
+// granularity and timestamp, and upserted, so collecting overlapping ranges again updates the existing rows
+type SQLiteRcv struct {
+	Path      string
+	Table     string
+	BatchSize int
+	DB        *sql.DB
+	Mutex     *sync.Mutex
+	upsert    string
+}
+
+// NewSQLite builds a SQLite Receiver, creating the database and candles table if they don't exist
+func NewSQLite(path string) (*SQLiteRcv, error) {
+	return NewSQLiteWithConfig(&SQLiteConfig{Path: path})
+}
+
+// NewSQLiteWithConfig builds a SQLite Receiver from the config, creating the database and table if they
+// don't exist
+func NewSQLiteWithConfig(config *SQLiteConfig) (*SQLiteRcv, error) {
+	table := config.Table
+	if table == "" {
+		table = DefaultSQLiteTable
+	}
+	if !sqlIdentifier.MatchString(table) {
+		return &SQLiteRcv{}, fmt.Errorf("Invalid SQLite table name [%s]", table)
+	}
+	batch := config.BatchSize
+	if batch <= 0 {
+		batch = DefaultSQLiteBatchSize
+	}
+
+	db, err := sql.Open("sqlite3", config.Path)
+	if err != nil {
+		return &SQLiteRcv{}, err
+	}
+	// a single connection serializes writes, which sqlite would otherwise reject with a busy error
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		product     TEXT    NOT NULL,
+		granularity INTEGER NOT NULL,
+		timestamp   INTEGER NOT NULL,
+		source      TEXT    NOT NULL,
+		datetime    TEXT    NOT NULL,
+		low         REAL    NOT NULL,
+		high        REAL    NOT NULL,
+		open        REAL    NOT NULL,
+		close       REAL    NOT NULL,
+		volume      REAL    NOT NULL,
+		fields      TEXT,
+		PRIMARY KEY (product, granularity, timestamp)
+	)`, table))
+	if err != nil {
+		db.Close()
+		return &SQLiteRcv{}, fmt.Errorf("Failed to create SQLite table [%s]: %s", table, err.Error())
+	}
+
+	return &SQLiteRcv{
+		Path:      config.Path,
+		Table:     table,
+		BatchSize: batch,
+		DB:        db,
+		Mutex:     &sync.Mutex{},
+		upsert: fmt.Sprintf(`INSERT INTO %s (product, granularity, timestamp, source, datetime, low, high, open, close, volume, fields)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (product, granularity, timestamp) DO UPDATE SET
+			source = excluded.source, datetime = excluded.datetime, low = excluded.low, high = excluded.high,
+			open = excluded.open, close = excluded.close, volume = excluded.volume, fields = excluded.fields`, table),
+	}, nil
+}
+
+// Collect upserts the candlestick into the table
+func (r *SQLiteRcv) Collect(c *extractor.Candlestick) error {
+	return r.CollectBatch([]*extractor.Candlestick{c})
+}
+
+// CollectBatch upserts the candlesticks into the table, in transactions of at most BatchSize candlesticks
+func (r *SQLiteRcv) CollectBatch(cdls []*extractor.Candlestick) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	for start := 0; start < len(cdls); start += r.BatchSize {
+		end := start + r.BatchSize
+		if end > len(cdls) {
+			end = len(cdls)
+		}
+		if err := r.write(cdls[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write upserts the candlesticks in a single transaction, which is rolled back if any of them fail
+func (r *SQLiteRcv) write(cdls []*extractor.Candlestick) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(r.upsert)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, c := range cdls {
+		var fields interface{}
+		if len(c.Fields) > 0 {
+			b, err := json.Marshal(c.Fields)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			fields = string(b)
+		}
+		_, err = stmt.Exec(c.Product, c.Granularity, c.Timestamp, c.Source, c.Datetime,
+			c.Low, c.High, c.Open, c.Close, c.Volume, fields)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to upsert candlestick [%s %d %d]: %s", c.Product, c.Granularity, c.Timestamp, err.Error())
+		}
+	}
+	return tx.Commit()
+}
+
+// Close closes the database
+func (r *SQLiteRcv) Close() {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	r.DB.Close()
+}
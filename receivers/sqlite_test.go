@@ -0,0 +1,113 @@
+//go:build cgo
+// +build cgo
+
+package receivers_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/receivers"
+)
+
+func TestSQLiteUpsert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "candles.db")
+
+	// the second collection overlaps the end of the first, with new closes, and is split into transactions
+	first := candles("BTC-USD", 100, 0, 60, 120, 180)
+	second := candles("BTC-USD", 200, 120, 180, 240)
+	second[0].Fields = map[string]float64{"sma_3": 150}
+	other := candles("ETH-USD", 10, 0, 60)
+	for i, batch := range [][]*extractor.Candlestick{first, second, other} {
+		// reopened for each collection, as a resumed extraction would be
+		rcv, err := receivers.NewSQLiteWithConfig(&receivers.SQLiteConfig{Path: path, BatchSize: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = rcv.CollectBatch(batch); err != nil {
+			t.Fatalf("collection %d: %s", i, err)
+		}
+		rcv.Close()
+	}
+
+	rcv, err := receivers.NewSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rcv.Close()
+
+	var count int
+	if err = rcv.DB.QueryRow(`SELECT COUNT(*) FROM candles`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Errorf("expected 7 rows, one per product and timestamp, got %d", count)
+	}
+
+	rows, err := rcv.DB.Query(`SELECT timestamp, close, fields FROM candles WHERE product = 'BTC-USD' ORDER BY timestamp`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	want := []struct {
+		ts     int64
+		close  float64
+		fields string
+	}{{0, 100, ""}, {60, 100, ""}, {120, 200, `{"sma_3":150}`}, {180, 200, ""}, {240, 200, ""}}
+	i := 0
+	for ; rows.Next(); i++ {
+		var ts int64
+		var close float64
+		var fields *string
+		if err = rows.Scan(&ts, &close, &fields); err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if fields != nil {
+			got = *fields
+		}
+		// the later collection wins where they overlap
+		if i >= len(want) {
+			t.Fatalf("unexpected row %d: %d %f %q", i, ts, close, got)
+		}
+		if ts != want[i].ts || close != want[i].close || got != want[i].fields {
+			t.Errorf("row %d: expected %+v, got %d %f %q", i, want[i], ts, close, got)
+		}
+	}
+	if err = rows.Err(); err != nil || i != len(want) {
+		t.Errorf("expected %d BTC-USD rows, got %d %v", len(want), i, err)
+	}
+}
+
+func TestSQLiteInvalidTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "candles.db")
+
+	for _, table := range []string{"candles; DROP TABLE candles", "1candles", "candles-btc", `"candles"`, "candles btc"} {
+		if _, err := receivers.NewSQLiteWithConfig(&receivers.SQLiteConfig{Path: path, Table: table}); err == nil {
+			t.Errorf("expected table [%s] to be rejected", table)
+		}
+	}
+	// nothing was created for the rejected names
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected no database to be created, got %v", err)
+	}
+
+	rcv, err := receivers.NewSQLiteWithConfig(&receivers.SQLiteConfig{Path: path, Table: "candles_btc_1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcv.Close()
+}
@@ -0,0 +1,117 @@
+package receivers
+
+import "bytes"
+
+// thrift compact protocol types, as used by the parquet file metadata
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// compactWriter encodes thrift structs with the compact protocol. only the types needed for the parquet
+// metadata are supported
+type compactWriter struct {
+	buf bytes.Buffer
+	// last is the id of the last field written in each open struct, as ids are written as deltas
+	last []int16
+}
+
+func newCompactWriter() *compactWriter {
+	return &compactWriter{last: []int16{0}}
+}
+
+func (w *compactWriter) field(id int16, typ byte) {
+	delta := id - w.last[len(w.last)-1]
+	if delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(zigzag(int64(id)))
+	}
+	w.last[len(w.last)-1] = id
+}
+
+func (w *compactWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *compactWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(zigzag(v))
+}
+
+func (w *compactWriter) string(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.stringElem(s)
+}
+
+func (w *compactWriter) binary(id int16, b []byte) {
+	w.field(id, thriftBinary)
+	w.varint(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+// bool writes the value as the type of the field, as the compact protocol has no separate value for it
+func (w *compactWriter) bool(id int16, v bool) {
+	if v {
+		w.field(id, thriftTrue)
+		return
+	}
+	w.field(id, thriftFalse)
+}
+
+// list starts a list field of n elements, which are written with the *Elem methods or as structs with
+// beginElem
+func (w *compactWriter) list(id int16, elemType byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | elemType)
+		return
+	}
+	w.buf.WriteByte(0xf0 | elemType)
+	w.varint(uint64(n))
+}
+
+func (w *compactWriter) i32Elem(v int32) {
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *compactWriter) stringElem(s string) {
+	w.varint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+// beginStruct starts a struct field, which is closed with end
+func (w *compactWriter) beginStruct(id int16) {
+	w.field(id, thriftStruct)
+	w.last = append(w.last, 0)
+}
+
+// beginElem starts a struct element of a list, which is closed with end
+func (w *compactWriter) beginElem() {
+	w.last = append(w.last, 0)
+}
+
+// end writes the stop field of the open struct
+func (w *compactWriter) end() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *compactWriter) varint(v uint64) {
+	for v >= 0x80 {
+		w.buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	w.buf.WriteByte(byte(v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
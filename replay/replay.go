@@ -0,0 +1,217 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/receivers"
+)
+
+// Formats of the files written by the file receivers
+const (
+	FormatCSV    = "csv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// Config provides values for the replay execution
+type Config struct {
+	// Paths are the files to replay, in order
+	Paths []string
+	// Format of the files. detected from each file extension if empty
+	Format string
+	// Products limits the replay to the products, if set
+	Products []string
+	// Granularity limits the replay to the granularity, if set
+	Granularity int
+	// Start and End limit the replay to candlesticks in [Start, End), if set
+	Start      time.Time
+	End        time.Time
+	BufferSize int
+}
+
+// Replay implements extractor.Collectable, reading candlesticks previously written by the CSV, JSON and
+// newline delimited JSON receivers. it can be used in place of the extractor when building a collector
+type Replay struct {
+	Config          *Config
+	CandlestickChan chan *extractor.Candlestick
+	ErrorChan       chan error
+	mutex           *sync.Mutex
+	cancel          context.CancelFunc
+	closed          bool
+}
+
+// New builds a replay of the files in the config
+func New(config *Config) *Replay {
+	return &Replay{
+		Config:          config,
+		CandlestickChan: make(chan *extractor.Candlestick, config.BufferSize),
+		ErrorChan:       make(chan error, config.BufferSize),
+		mutex:           &sync.Mutex{},
+	}
+}
+
+// Start reads the files in the background, writing each matching candlestick to the channel
+func (r *Replay) Start() error {
+	return r.StartContext(context.Background())
+}
+
+// StartContext begins the replay as Start does. Cancelling the context ends the replay and closes the channels
+func (r *Replay) StartContext(ctx context.Context) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cancel != nil {
+		return errors.New("Replay already started")
+	}
+	if len(r.Config.Paths) == 0 {
+		return errors.New("No files set for the replay")
+	}
+
+	// open every file upfront, so missing files are reported before anything is sent
+	rdrs := make([]receivers.CandleReader, len(r.Config.Paths))
+	files := make([]*os.File, len(r.Config.Paths))
+	for i, path := range r.Config.Paths {
+		f, err := os.Open(path)
+		if err != nil {
+			closeAll(files)
+			return err
+		}
+		files[i] = f
+		if rdrs[i], err = r.reader(path, f); err != nil {
+			closeAll(files)
+			return err
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	go func() {
+		defer cancel()
+		defer closeAll(files)
+		defer r.closeChannels()
+		for i, rdr := range rdrs {
+			if !r.replay(runCtx, r.Config.Paths[i], rdr) {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends the replay. the channels are closed once the running replay exits, or immediately if it was never started
+func (r *Replay) Stop() {
+	r.mutex.Lock()
+	cancel := r.cancel
+	r.mutex.Unlock()
+	if cancel != nil {
+		cancel()
+		return
+	}
+	r.closeChannels()
+}
+
+// Candlesticks returns the candlestick channel
+func (r *Replay) Candlesticks() chan *extractor.Candlestick {
+	return r.CandlestickChan
+}
+
+// Errors returns the error channel
+func (r *Replay) Errors() chan error {
+	return r.ErrorChan
+}
+
+// replay sends the matching candlesticks of a single file, returning false if the context was cancelled
+func (r *Replay) replay(ctx context.Context, path string, rdr receivers.CandleReader) bool {
+	for {
+		cdl, err := rdr.Read()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			// a malformed file can't be read any further, move on to the next
+			select {
+			case r.ErrorChan <- fmt.Errorf("Replay Error: [%s] %s", path, err.Error()):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		if !r.matches(cdl) {
+			continue
+		}
+
+		select {
+		case r.CandlestickChan <- cdl:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// matches reports whether the candlestick passes the filters of the config
+func (r *Replay) matches(c *extractor.Candlestick) bool {
+	cfg := r.Config
+	if cfg.Granularity != 0 && c.Granularity != cfg.Granularity {
+		return false
+	}
+	if !cfg.Start.IsZero() && c.Timestamp < cfg.Start.Unix() {
+		return false
+	}
+	if !cfg.End.IsZero() && c.Timestamp >= cfg.End.Unix() {
+		return false
+	}
+	if len(cfg.Products) == 0 {
+		return true
+	}
+	for _, p := range cfg.Products {
+		if p == c.Product {
+			return true
+		}
+	}
+	return false
+}
+
+// reader builds the reader for the format of the file
+func (r *Replay) reader(path string, f io.Reader) (receivers.CandleReader, error) {
+	format := r.Config.Format
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	switch format {
+	case FormatCSV:
+		return receivers.NewCSVReader(f), nil
+	case FormatJSON:
+		return receivers.NewJSONReader(f), nil
+	case FormatNDJSON, "jsonl":
+		return receivers.NewNDJSONReader(f), nil
+	}
+	return nil, fmt.Errorf("Unknown replay format [%s] for file [%s]", format, path)
+}
+
+// closeChannels closes the candlestick and error channels if they are not already closed
+func (r *Replay) closeChannels() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.CandlestickChan)
+	close(r.ErrorChan)
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
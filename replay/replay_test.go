@@ -0,0 +1,105 @@
+package replay_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/gdaxtest"
+	"github.com/johnhof/gdax-candle-extractor/receivers"
+	"github.com/johnhof/gdax-candle-extractor/replay"
+)
+
+// testStart is far enough in the past that a default extraction window wouldn't include it
+var testStart = time.Date(2017, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+// writeFiles writes the candlesticks with each file receiver, returning the paths
+func writeFiles(t *testing.T, dir string, cdls []extractor.Candlestick) []string {
+	csv, err := receivers.NewCSV(filepath.Join(dir, "candles.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := receivers.NewJSON(filepath.Join(dir, "candles.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ndjson, err := receivers.NewNDJSON(filepath.Join(dir, "candles.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcvs := []extractor.Receiver{csv, json, ndjson}
+	for _, rcv := range rcvs {
+		for i := range cdls {
+			if err = rcv.Collect(&cdls[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rcv.Close()
+	}
+	return []string{csv.Path, json.Path, ndjson.Path}
+}
+
+// read replays the file, returning the candlesticks
+func read(t *testing.T, config *replay.Config) []*extractor.Candlestick {
+	rp := replay.New(config)
+	if err := rp.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for err := range rp.Errors() {
+			t.Errorf("unexpected replay error: %s", err)
+		}
+	}()
+	var out []*extractor.Candlestick
+	for c := range rp.Candlesticks() {
+		out = append(out, c)
+	}
+	<-done
+	return out
+}
+
+func TestReplayFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var cdls []extractor.Candlestick
+	for i := 0; i < 10; i++ {
+		cdls = append(cdls, gdaxtest.Candle("BTC-USD", 60, testStart.Add(time.Duration(i)*time.Minute)))
+	}
+	paths := writeFiles(t, dir, cdls)
+
+	for _, path := range paths {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			// without a start or end, every candlestick is replayed regardless of its age
+			all := read(t, &replay.Config{Paths: []string{path}})
+			if len(all) != len(cdls) {
+				t.Fatalf("expected %d candlesticks, got %d", len(cdls), len(all))
+			}
+			for i, c := range all {
+				want := cdls[i]
+				if c.Product != want.Product || c.Source != want.Source || c.Granularity != want.Granularity ||
+					c.Timestamp != want.Timestamp || c.Open != want.Open || c.High != want.High ||
+					c.Low != want.Low || c.Close != want.Close || c.Volume != want.Volume {
+					t.Errorf("candlestick %d: expected %+v, got %+v", i, want, *c)
+				}
+			}
+
+			filtered := read(t, &replay.Config{
+				Paths: []string{path},
+				Start: testStart.Add(2 * time.Minute),
+				End:   testStart.Add(5 * time.Minute),
+			})
+			if len(filtered) != 3 || filtered[0].Timestamp != testStart.Add(2*time.Minute).Unix() {
+				t.Errorf("expected the 3 candlesticks in [start, end), got %d", len(filtered))
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package resample
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+)
+
+var (
+	// MidnightUTC aligns buckets to UTC midnight. this is the default for granularities which aren't whole weeks
+	MidnightUTC = time.Unix(0, 0).UTC()
+	// MondayUTC aligns buckets to midnight on Monday. this is the default for whole week granularities
+	MondayUTC = time.Date(1970, time.January, 5, 0, 0, 0, 0, time.UTC)
+)
+
+// week is the length of a week in seconds
+const week = 7 * 24 * 60 * 60
+
+// Config provides values for the resampling execution
+type Config struct {
+	// Granularity in seconds of the resampled candlesticks. it must be a multiple of the source granularity
+	Granularity int
+	// Origin is the time buckets are aligned to. defaults to MondayUTC for whole weeks, otherwise MidnightUTC
+	Origin time.Time
+	// Lateness is how far past the end of a bucket the stream must reach before an incomplete bucket is
+	// emitted. defaults to 200 source candlesticks, covering the newest-first order of each GDAX request
+	Lateness time.Duration
+	// BufferSize is the size of the resampled candlestick buffer
+	BufferSize int
+}
+
+// Aggregator resamples candlesticks to a coarser granularity as they are added. candlesticks may be added in
+// any order within the lateness of the config, and resampled candlesticks are returned in chronological
+// order. buckets are kept separately for each product and source
+type Aggregator struct {
+	Config  *Config
+	buckets map[string]map[int64]*bucket
+	latest  map[string]int64
+	// emitted tracks the buckets already returned, within the lateness of the latest candlestick
+	emitted map[string]map[int64]bool
+}
+
+// bucket is an aggregated candlestick in progress
+type bucket struct {
+	cdl   extractor.Candlestick
+	first int64
+	last  int64
+	seen  map[int64]bool
+	// sources are the candlesticks folded into the bucket
+	sources []*extractor.Candlestick
+}
+
+// NewAggregator builds an aggregator for the config
+func NewAggregator(config *Config) (*Aggregator, error) {
+	if config.Granularity <= 0 {
+		return nil, fmt.Errorf("Invalid resample granularity [%d]", config.Granularity)
+	}
+	if config.Origin.IsZero() {
+		config.Origin = MidnightUTC
+		if config.Granularity%week == 0 {
+			config.Origin = MondayUTC
+		}
+	}
+	return &Aggregator{
+		Config:  config,
+		buckets: map[string]map[int64]*bucket{},
+		latest:  map[string]int64{},
+		emitted: map[string]map[int64]bool{},
+	}, nil
+}
+
+// Add folds the candlestick into its bucket, returning any buckets which are complete
+func (a *Aggregator) Add(c *extractor.Candlestick) ([]*extractor.Candlestick, error) {
+	bs, _, err := a.add(c)
+	return candles(bs), err
+}
+
+// add folds the candlestick into its bucket as Add does, returning the complete buckets. false is returned
+// if the candlestick was dropped, as a duplicate or too late for its bucket
+func (a *Aggregator) add(c *extractor.Candlestick) ([]*bucket, bool, error) {
+	g := a.Config.Granularity
+	if c.Granularity <= 0 || g%c.Granularity != 0 {
+		return nil, false, fmt.Errorf("Cannot resample [%s] granularity [%d] to [%d]: not a multiple", c.Product, c.Granularity, g)
+	}
+
+	key := fmt.Sprintf("%s:%s:%d", c.Source, c.Product, c.Granularity)
+	if a.buckets[key] == nil {
+		a.buckets[key] = map[int64]*bucket{}
+		a.emitted[key] = map[int64]bool{}
+	}
+	start := a.align(c.Timestamp)
+
+	// drop candlesticks for buckets which were already emitted, such as the boundary candlestick
+	// GDAX returns in two adjacent requests
+	if a.emitted[key][start] || start+int64(g)+a.lateness(c.Granularity) <= a.latest[key] {
+		return nil, false, nil
+	}
+
+	b, ok := a.buckets[key][start]
+	switch {
+	case !ok:
+		b = &bucket{cdl: *c, first: c.Timestamp, last: c.Timestamp, seen: map[int64]bool{}}
+		b.cdl.Granularity = g
+		b.cdl.Timestamp = start
+		b.cdl.Datetime = time.Unix(start, 0).UTC().String()
+		a.buckets[key][start] = b
+	case b.seen[c.Timestamp]:
+		// duplicate of a candlestick already in the bucket
+		return nil, false, nil
+	case c.Timestamp > b.last:
+		b.cdl.Merge(c)
+		b.last = c.Timestamp
+	case c.Timestamp < b.first:
+		// fold the bucket into the earlier candlestick, keeping the earlier open
+		earlier := *c
+		earlier.Merge(&b.cdl)
+		earlier.Granularity, earlier.Timestamp, earlier.Datetime = b.cdl.Granularity, b.cdl.Timestamp, b.cdl.Datetime
+		b.cdl = earlier
+		b.first = c.Timestamp
+	default:
+		// between the first and last, so neither the open or close change
+		mid := b.cdl
+		mid.Merge(c)
+		b.cdl.High, b.cdl.Low, b.cdl.Volume = mid.High, mid.Low, mid.Volume
+	}
+	b.seen[c.Timestamp] = true
+	b.sources = append(b.sources, c)
+
+	if c.Timestamp > a.latest[key] {
+		a.latest[key] = c.Timestamp
+	}
+	return a.emit(key, c.Granularity, false), true, nil
+}
+
+// Flush returns every remaining bucket, complete or not. used at the end of the stream
+func (a *Aggregator) Flush() []*extractor.Candlestick {
+	return candles(a.flush())
+}
+
+func (a *Aggregator) flush() []*bucket {
+	var out []*bucket
+	keys := make([]string, 0, len(a.buckets))
+	for key := range a.buckets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		out = append(out, a.emit(key, 0, true)...)
+	}
+	return out
+}
+
+// emit removes and returns the buckets of the key which are full, or which the stream has passed by
+// more than the lateness. buckets are returned in chronological order, across calls as well as within
+// them, so a full bucket is held until the bucket before it was emitted or can no longer be started
+func (a *Aggregator) emit(key string, srcGranularity int, all bool) []*bucket {
+	g := int64(a.Config.Granularity)
+	lateness := a.lateness(srcGranularity)
+
+	starts := make([]int64, 0, len(a.buckets[key]))
+	for start := range a.buckets[key] {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var out []*bucket
+	for _, start := range starts {
+		b := a.buckets[key][start]
+		full := srcGranularity > 0 && len(b.seen) >= int(g)/srcGranularity
+		passed := a.latest[key] >= start+g+lateness
+		// an earlier bucket may still be started unless its predecessor was emitted or passed
+		settled := a.emitted[key][start-g] || a.latest[key] >= start+lateness
+		if !all && !passed && !(full && settled) {
+			break
+		}
+		out = append(out, b)
+		delete(a.buckets[key], start)
+		a.emitted[key][start] = true
+	}
+
+	// forget emitted buckets which are beyond the lateness, as their candlesticks are dropped regardless
+	for start := range a.emitted[key] {
+		if start+g+lateness <= a.latest[key] {
+			delete(a.emitted[key], start)
+		}
+	}
+	return out
+}
+
+// candles returns the aggregated candlesticks of the buckets
+func candles(bs []*bucket) []*extractor.Candlestick {
+	out := make([]*extractor.Candlestick, len(bs))
+	for i, b := range bs {
+		out[i] = &b.cdl
+	}
+	return out
+}
+
+// lateness returns the lateness in seconds for candlesticks of the source granularity
+func (a *Aggregator) lateness(srcGranularity int) int64 {
+	if a.Config.Lateness > 0 {
+		return int64(a.Config.Lateness / time.Second)
+	}
+	return int64(srcGranularity) * 200
+}
+
+// align returns the start of the bucket containing the timestamp
+func (a *Aggregator) align(ts int64) int64 {
+	g := int64(a.Config.Granularity)
+	offset := a.Config.Origin.Unix()
+	rel := ts - offset
+	start := rel - rel%g
+	if rel < 0 && rel%g != 0 {
+		start -= g
+	}
+	return start + offset
+}
+
+// Resampler implements extractor.Collectable, resampling the candlesticks of another Collectable. it
+// can be used in place of the extractor when building a collector. it implements extractor.Acknowledger,
+// acknowledging the candlesticks each resampled candlestick was aggregated from to the source, so a
+// checkpointed extraction can be resampled
+type Resampler struct {
+	Source          extractor.Collectable
+	Aggregator      *Aggregator
+	CandlestickChan chan *extractor.Candlestick
+	ErrorChan       chan error
+	GapChan         chan *extractor.GapEvent
+	// sources holds the source candlesticks of each resampled candlestick until it's acknowledged
+	sources map[*extractor.Candlestick][]*extractor.Candlestick
+	mutex   *sync.Mutex
+}
+
+// New builds a resampler, and begins reading from the source
+func New(src extractor.Collectable, config *Config) (*Resampler, error) {
+	agg, err := NewAggregator(config)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Resampler{
+		Source:          src,
+		Aggregator:      agg,
+		CandlestickChan: make(chan *extractor.Candlestick, config.BufferSize),
+		ErrorChan:       make(chan error, config.BufferSize),
+		GapChan:         make(chan *extractor.GapEvent, config.BufferSize),
+		sources:         map[*extractor.Candlestick][]*extractor.Candlestick{},
+		mutex:           &sync.Mutex{},
+	}
+	go r.run()
+	return r, nil
+}
+
+// run resamples candlesticks and forwards errors from the source until both of its channels close
+func (r *Resampler) run() {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for cdl := range r.Source.Candlesticks() {
+			out, added, err := r.Aggregator.add(cdl)
+			if err != nil {
+				// left unacknowledged, so the source doesn't checkpoint past it
+				r.ErrorChan <- err
+			} else if !added {
+				// its bucket was already sent, so there's nothing to wait for
+				if err = r.ackSources([]*extractor.Candlestick{cdl}); err != nil {
+					r.ErrorChan <- err
+				}
+			}
+			r.send(out)
+		}
+		r.send(r.Aggregator.flush())
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for err := range r.Source.Errors() {
+			r.ErrorChan <- err
+		}
+	}()
+
+	// forward gaps so a source reporting them is never blocked
+	if gr, ok := r.Source.(extractor.GapReporter); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for gap := range gr.Gaps() {
+				r.GapChan <- gap
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(r.CandlestickChan)
+	close(r.ErrorChan)
+	close(r.GapChan)
+}
+
+// send passes the resampled candlesticks of the buckets to the channel, tracking their sources first so
+// acknowledgements can't arrive ahead of them
+func (r *Resampler) send(bs []*bucket) {
+	if _, ok := r.Source.(extractor.Acknowledger); ok {
+		r.mutex.Lock()
+		for _, b := range bs {
+			r.sources[&b.cdl] = b.sources
+		}
+		r.mutex.Unlock()
+	}
+	for _, b := range bs {
+		r.CandlestickChan <- &b.cdl
+	}
+}
+
+// Ack acknowledges each candlestick the resampled candlestick was aggregated from to the source, if the
+// source is an extractor.Acknowledger
+func (r *Resampler) Ack(cdl *extractor.Candlestick) error {
+	r.mutex.Lock()
+	srcs := r.sources[cdl]
+	delete(r.sources, cdl)
+	r.mutex.Unlock()
+	return r.ackSources(srcs)
+}
+
+func (r *Resampler) ackSources(cdls []*extractor.Candlestick) error {
+	ack, ok := r.Source.(extractor.Acknowledger)
+	if !ok {
+		return nil
+	}
+	for _, c := range cdls {
+		if err := ack.Ack(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Candlesticks returns the resampled candlestick channel
+func (r *Resampler) Candlesticks() chan *extractor.Candlestick {
+	return r.CandlestickChan
+}
+
+// Errors returns the error channel, carrying both source and resampling errors
+func (r *Resampler) Errors() chan error {
+	return r.ErrorChan
+}
+
+// Gaps returns the gap channel, carrying gaps reported by the source
+func (r *Resampler) Gaps() chan *extractor.GapEvent {
+	return r.GapChan
+}
+
+// Stop stops the source. the resampler channels close once the source channels are closed
+func (r *Resampler) Stop() {
+	r.Source.Stop()
+}
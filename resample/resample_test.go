@@ -0,0 +1,125 @@
+package resample_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/gdaxtest"
+	"github.com/johnhof/gdax-candle-extractor/resample"
+)
+
+var testStart = time.Date(2017, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+type memReceiver struct {
+	mutex sync.Mutex
+	cdls  []*extractor.Candlestick
+}
+
+func (r *memReceiver) Collect(c *extractor.Candlestick) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cdls = append(r.cdls, c)
+	return nil
+}
+
+func (r *memReceiver) Close() {}
+
+func TestAggregatorOutOfOrder(t *testing.T) {
+	agg, err := resample.NewAggregator(&resample.Config{Granularity: 300, Lateness: 5 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []*extractor.Candlestick
+	// two pages in order, each newest first as GDAX responds
+	for _, page := range []int{0, 10} {
+		for i := page + 9; i >= page; i-- {
+			c := gdaxtest.Candle("BTC-USD", 60, testStart.Add(time.Duration(i)*time.Minute))
+			cdls, err := agg.Add(&c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, cdls...)
+		}
+	}
+	if len(out) != 4 {
+		t.Fatalf("expected 4 buckets before the flush, got %d", len(out))
+	}
+	if rest := agg.Flush(); len(rest) != 0 {
+		t.Fatalf("expected nothing left to flush, got %d buckets", len(rest))
+	}
+
+	// the later bucket of each page is filled first, but buckets are emitted in chronological order
+	for i, b := range out {
+		if want := testStart.Add(time.Duration(i) * 5 * time.Minute).Unix(); b.Timestamp != want || b.Granularity != 300 {
+			t.Errorf("bucket %d: expected timestamp %d, got %+v", i, want, *b)
+		}
+	}
+	b := out[0]
+	first := gdaxtest.Candle("BTC-USD", 60, testStart)
+	last := gdaxtest.Candle("BTC-USD", 60, testStart.Add(4*time.Minute))
+	if b.Open != first.Open || b.Close != last.Close {
+		t.Errorf("expected open %f and close %f, got %f and %f", first.Open, last.Close, b.Open, b.Close)
+	}
+}
+
+func TestResamplerCheckpoints(t *testing.T) {
+	srv := gdaxtest.NewServer(nil)
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "resample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := extractor.NewFileCheckpoint(filepath.Join(dir, "checkpoint.json"))
+
+	end := testStart.Add(1000 * time.Minute)
+	ext := extractor.New(&extractor.ExtractorConfig{
+		BaseURL:     srv.URL,
+		HTTPClient:  &http.Client{Timeout: 5 * time.Second},
+		RateLimiter: extractor.NewTokenBucket(1000, 100),
+		Checkpoint:  store,
+		Extraction: &extractor.ExtractionConfig{
+			Product:     "BTC-USD",
+			Start:       testStart,
+			End:         end,
+			Granularity: 60,
+		},
+	})
+	if err = ext.Start(); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := resample.New(ext, &resample.Config{Granularity: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcv := &memReceiver{}
+	col := extractor.NewCollector(&extractor.CollectorConfig{
+		Extractor: rs,
+		Receivers: []extractor.Receiver{rcv},
+		ErrorHandler: func(err error) {
+			t.Errorf("unexpected error: %s", err)
+		},
+	})
+	if err = col.Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a bucket every 5 minutes, including the partial bucket of the final candlestick
+	if len(rcv.cdls) != 201 {
+		t.Errorf("expected 201 resampled candlesticks, got %d", len(rcv.cdls))
+	}
+	cp, ok, err := store.Load("BTC-USD", 60)
+	if err != nil || !ok {
+		t.Fatalf("expected a checkpoint to be saved, got %t %v", ok, err)
+	}
+	if !cp.Equal(end) {
+		t.Errorf("expected the checkpoint at %s, got %s", end, cp)
+	}
+}
@@ -0,0 +1,198 @@
+package transforms
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+)
+
+// Fields is the set of candlestick fields, by their JSON names, which can be kept by ClearFields or rounded
+var Fields = []string{"product", "source", "datetime", "granularity", "low", "high", "open", "close", "volume", "timestamp"}
+
+// KeyFields identify a candlestick, and receivers such as SQLite, Postgres and Elasticsearch key their rows
+// by them, so they can't be cleared by ClearFields
+var KeyFields = []string{"product", "granularity", "timestamp"}
+
+// priceFields are rounded if no fields are given to NewRound
+var priceFields = []string{"low", "high", "open", "close", "volume"}
+
+// Filter implements extractor.Transform, keeping the candlesticks for which Keep returns true
+type Filter struct {
+	Keep func(*extractor.Candlestick) bool
+}
+
+// NewFilter builds a transform which drops every candlestick keep returns false for
+func NewFilter(keep func(*extractor.Candlestick) bool) *Filter {
+	return &Filter{Keep: keep}
+}
+
+// Apply passes the candlestick on if it's kept
+func (f *Filter) Apply(c *extractor.Candlestick) ([]*extractor.Candlestick, error) {
+	if !f.Keep(c) {
+		return nil, nil
+	}
+	return []*extractor.Candlestick{c}, nil
+}
+
+// Flush acts as a no-op, as nothing is held back
+func (f *Filter) Flush() ([]*extractor.Candlestick, error) {
+	return nil, nil
+}
+
+// HoldsBack implements extractor.Holder, as nothing is held back
+func (f *Filter) HoldsBack() bool {
+	return false
+}
+
+// NewTimeWindow builds a transform which keeps candlesticks starting at or after start and before end.
+// a zero start or end leaves that side of the window open
+func NewTimeWindow(start time.Time, end time.Time) *Filter {
+	return NewFilter(func(c *extractor.Candlestick) bool {
+		if !start.IsZero() && c.Timestamp < start.Unix() {
+			return false
+		}
+		return end.IsZero() || c.Timestamp < end.Unix()
+	})
+}
+
+// NewDropZeroVolume builds a transform which drops candlesticks without any trades
+func NewDropZeroVolume() *Filter {
+	return NewFilter(func(c *extractor.Candlestick) bool {
+		return c.Volume != 0
+	})
+}
+
+// ClearFields implements extractor.Transform, setting every field of the candlestick which isn't kept to
+// its zero value. it doesn't change the columns receivers write, so a cleared field is still written, as
+// zero or empty, and can't be told apart from a real zero. computed values in the candlestick's Fields
+// are kept
+type ClearFields struct {
+	Keep map[string]bool
+}
+
+// NewClearFields builds a transform which clears every field except those named, by their JSON names
+// (see Fields). every key field must be named
+func NewClearFields(keep ...string) (*ClearFields, error) {
+	if err := checkFields(keep); err != nil {
+		return &ClearFields{}, err
+	}
+	cf := &ClearFields{Keep: map[string]bool{}}
+	for _, f := range keep {
+		cf.Keep[f] = true
+	}
+	for _, f := range KeyFields {
+		if !cf.Keep[f] {
+			return &ClearFields{}, fmt.Errorf("Field [%s] can't be cleared, as candlesticks are keyed by [%s]", f, strings.Join(KeyFields, ", "))
+		}
+	}
+	return cf, nil
+}
+
+// Apply passes on a copy of the candlestick with only the kept fields set
+func (cf *ClearFields) Apply(c *extractor.Candlestick) ([]*extractor.Candlestick, error) {
+	out := &extractor.Candlestick{Fields: c.Fields}
+	for _, f := range Fields {
+		if cf.Keep[f] {
+			copyField(out, c, f)
+		}
+	}
+	return []*extractor.Candlestick{out}, nil
+}
+
+// Flush acts as a no-op, as nothing is held back
+func (cf *ClearFields) Flush() ([]*extractor.Candlestick, error) {
+	return nil, nil
+}
+
+// HoldsBack implements extractor.Holder, as nothing is held back
+func (cf *ClearFields) HoldsBack() bool {
+	return false
+}
+
+// Round implements extractor.Transform, rounding numeric fields to a number of decimal places
+type Round struct {
+	Places int
+	Fields []string
+}
+
+// NewRound builds a transform which rounds the named fields to the decimal places. the prices and
+// volume are rounded if no fields are named
+func NewRound(places int, fields ...string) (*Round, error) {
+	if len(fields) == 0 {
+		fields = priceFields
+	}
+	for _, f := range fields {
+		if f != "low" && f != "high" && f != "open" && f != "close" && f != "volume" {
+			return &Round{}, fmt.Errorf("Field [%s] can't be rounded, expected one of [%s]", f, strings.Join(priceFields, ", "))
+		}
+	}
+	return &Round{Places: places, Fields: fields}, nil
+}
+
+// Apply passes on a copy of the candlestick with the fields rounded
+func (r *Round) Apply(c *extractor.Candlestick) ([]*extractor.Candlestick, error) {
+	out := *c
+	scale := math.Pow(10, float64(r.Places))
+	for _, f := range r.Fields {
+		v := floatField(&out, f)
+		*v = math.Floor(*v*scale+0.5) / scale
+	}
+	return []*extractor.Candlestick{&out}, nil
+}
+
+// Flush acts as a no-op, as nothing is held back
+func (r *Round) Flush() ([]*extractor.Candlestick, error) {
+	return nil, nil
+}
+
+// HoldsBack implements extractor.Holder, as nothing is held back
+func (r *Round) HoldsBack() bool {
+	return false
+}
+
+func checkFields(fields []string) error {
+	for _, f := range fields {
+		known := false
+		for _, k := range Fields {
+			known = known || f == k
+		}
+		if !known {
+			return fmt.Errorf("Unknown field [%s], expected one of [%s]", f, strings.Join(Fields, ", "))
+		}
+	}
+	return nil
+}
+
+func copyField(dst *extractor.Candlestick, src *extractor.Candlestick, field string) {
+	switch field {
+	case "product":
+		dst.Product = src.Product
+	case "source":
+		dst.Source = src.Source
+	case "datetime":
+		dst.Datetime = src.Datetime
+	case "granularity":
+		dst.Granularity = src.Granularity
+	case "timestamp":
+		dst.Timestamp = src.Timestamp
+	default:
+		*floatField(dst, field) = *floatField(src, field)
+	}
+}
+
+func floatField(c *extractor.Candlestick, field string) *float64 {
+	switch field {
+	case "low":
+		return &c.Low
+	case "high":
+		return &c.High
+	case "open":
+		return &c.Open
+	case "close":
+		return &c.Close
+	}
+	return &c.Volume
+}
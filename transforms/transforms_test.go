@@ -0,0 +1,138 @@
+package transforms_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/johnhof/gdax-candle-extractor/extractor"
+	"github.com/johnhof/gdax-candle-extractor/transforms"
+)
+
+var testStart = time.Date(2017, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+func candle(ts time.Time, volume float64) *extractor.Candlestick {
+	return &extractor.Candlestick{
+		Product:     "BTC-USD",
+		Source:      "gdax",
+		Datetime:    ts.String(),
+		Granularity: 60,
+		Timestamp:   ts.Unix(),
+		Low:         6400.125,
+		High:        6500.875,
+		Open:        6410.375,
+		Close:       6490.444,
+		Volume:      volume,
+		Fields:      map[string]float64{"sma_3": 6450.5},
+	}
+}
+
+// apply passes each candlestick through the transform, failing on any error
+func apply(t *testing.T, tf extractor.Transform, cdls ...*extractor.Candlestick) []*extractor.Candlestick {
+	t.Helper()
+	var out []*extractor.Candlestick
+	for _, c := range cdls {
+		res, err := tf.Apply(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, res...)
+	}
+	flushed, err := tf.Flush()
+	if err != nil || len(flushed) > 0 {
+		t.Fatalf("expected nothing to be flushed, got %d candlesticks and %v", len(flushed), err)
+	}
+	return append(out, flushed...)
+}
+
+func TestTimeWindow(t *testing.T) {
+	var cdls []*extractor.Candlestick
+	for i := 0; i < 5; i++ {
+		cdls = append(cdls, candle(testStart.Add(time.Duration(i)*time.Minute), 1))
+	}
+	cases := []struct {
+		name       string
+		start, end time.Time
+		first      int
+		count      int
+	}{
+		{"closed", testStart.Add(time.Minute), testStart.Add(3 * time.Minute), 1, 2},
+		{"open start", time.Time{}, testStart.Add(2 * time.Minute), 0, 2},
+		{"open end", testStart.Add(3 * time.Minute), time.Time{}, 3, 2},
+		{"open", time.Time{}, time.Time{}, 0, 5},
+	}
+	for _, tc := range cases {
+		out := apply(t, transforms.NewTimeWindow(tc.start, tc.end), cdls...)
+		if len(out) != tc.count || (len(out) > 0 && out[0] != cdls[tc.first]) {
+			t.Errorf("%s: expected %d candlesticks from %d, got %d", tc.name, tc.count, tc.first, len(out))
+		}
+	}
+}
+
+func TestDropZeroVolume(t *testing.T) {
+	traded := candle(testStart, 0.5)
+	out := apply(t, transforms.NewDropZeroVolume(), candle(testStart, 0), traded)
+	if len(out) != 1 || out[0] != traded {
+		t.Errorf("expected only the traded candlestick, got %d", len(out))
+	}
+}
+
+func TestClearFields(t *testing.T) {
+	c := candle(testStart, 12.5)
+	cf, err := transforms.NewClearFields("product", "granularity", "timestamp", "close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := apply(t, cf, c)
+	// the computed fields are kept
+	want := extractor.Candlestick{Product: c.Product, Granularity: c.Granularity, Timestamp: c.Timestamp, Close: c.Close, Fields: c.Fields}
+	if len(out) != 1 || !reflect.DeepEqual(*out[0], want) {
+		t.Errorf("expected %+v, got %+v", want, *out[0])
+	}
+	if c.Open == 0 || c.Source == "" {
+		t.Error("expected the original candlestick to be left unchanged")
+	}
+}
+
+func TestClearFieldsInvalid(t *testing.T) {
+	cases := [][]string{
+		{"product", "granularity", "timestamp", "price"},
+		// the key fields can't be cleared
+		{"granularity", "timestamp", "close"},
+		{"product", "timestamp", "close"},
+		{"product", "granularity", "close"},
+		{},
+	}
+	for _, keep := range cases {
+		if _, err := transforms.NewClearFields(keep...); err == nil {
+			t.Errorf("expected keeping %v to be rejected", keep)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	c := candle(testStart, 1.23456)
+	r, err := transforms.NewRound(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := apply(t, r, c)[0]
+	if got.Low != 6400.13 || got.High != 6500.88 || got.Open != 6410.38 || got.Close != 6490.44 || got.Volume != 1.23 {
+		t.Errorf("expected the prices and volume rounded to 2 places, got %+v", *got)
+	}
+	if c.Low != 6400.125 {
+		t.Error("expected the original candlestick to be left unchanged")
+	}
+
+	r, err = transforms.NewRound(0, "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got = apply(t, r, c)[0]; got.Volume != 1 || got.Low != c.Low {
+		t.Errorf("expected only the volume to be rounded, got %+v", *got)
+	}
+
+	if _, err = transforms.NewRound(2, "timestamp"); err == nil {
+		t.Error("expected rounding the timestamp to be rejected")
+	}
+}